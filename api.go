@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/MAKLs/nextcloud-exporter/exporter"
+	"github.com/MAKLs/nextcloud-exporter/models"
+)
+
+type serverInfoResponse struct {
+	Target         string    `json:"target"`
+	FetchedAt      time.Time `json:"fetched_at"`
+	LatencySeconds float64   `json:"latency_seconds"`
+	// Body as returned by Nextcloud, embedded as JSON or as an XML string
+	Raw interface{} `json:"raw"`
+	// Decoded server info, with flags as booleans and sizes as numbers
+	Normalized *models.NCServerInfo `json:"normalized"`
+}
+
+// Serve the last server info fetched from a target, so tools can use the
+// exporter as a cached proxy holding the Nextcloud credentials
+func serverInfo(ncExporter *exporter.NCExporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := ncExporter.Target()
+		if requested := r.URL.Query().Get("target"); requested != "" && requested != target {
+			http.Error(w, "unknown target", http.StatusNotFound)
+			return
+		}
+
+		snapshot := ncExporter.LastSnapshot()
+		if snapshot == nil {
+			http.Error(w, "no server info fetched yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		response := serverInfoResponse{
+			Target:         target,
+			FetchedAt:      snapshot.FetchedAt,
+			LatencySeconds: snapshot.Latency.Seconds(),
+			Raw:            string(snapshot.Info.Raw),
+			Normalized:     snapshot.Info,
+		}
+		if json.Valid(snapshot.Info.Raw) {
+			response.Raw = json.RawMessage(snapshot.Info.Raw)
+		}
+
+		body, err := json.Marshal(response)
+		if err != nil {
+			log.Printf("failed to serialize server info: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	})
+}
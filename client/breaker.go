@@ -0,0 +1,107 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/MAKLs/nextcloud-exporter/metrics"
+)
+
+// States of a circuit breaker
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+var breakerStates = []string{breakerClosed, breakerOpen, breakerHalfOpen}
+
+// Circuit breaker that suspends requests after consecutive failures.
+// Once the reset timeout passes, a single trial request is let through
+// and its outcome decides whether the breaker closes or opens again
+type circuitBreaker struct {
+	lock         sync.Mutex
+	threshold    uint
+	resetTimeout time.Duration
+	failures     uint
+	state        string
+	openedAt     time.Time
+}
+
+func newCircuitBreaker(threshold uint, resetTimeout time.Duration) *circuitBreaker {
+	breaker := &circuitBreaker{threshold: threshold, resetTimeout: resetTimeout}
+	breaker.setState(breakerClosed)
+	return breaker
+}
+
+// Must be called with the lock held
+func (b *circuitBreaker) setState(state string) {
+	b.state = state
+	for _, s := range breakerStates {
+		if s == state {
+			metrics.BreakerState.WithLabelValues(s).Set(1)
+		} else {
+			metrics.BreakerState.WithLabelValues(s).Set(0)
+		}
+	}
+}
+
+func (b *circuitBreaker) currentState() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
+
+// Check whether a request may be made
+func (b *circuitBreaker) allow() bool {
+	if b.threshold == 0 {
+		return true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.resetTimeout {
+			return false
+		}
+		// This caller makes the trial request
+		b.setState(breakerHalfOpen)
+		return true
+	case breakerHalfOpen:
+		// The trial request is in flight, its outcome closes or reopens the breaker
+		return false
+	default:
+		return true
+	}
+}
+
+func (b *circuitBreaker) recordSuccess() {
+	if b.threshold == 0 {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.failures = 0
+	if b.state != breakerClosed {
+		b.setState(breakerClosed)
+	}
+}
+
+func (b *circuitBreaker) recordFailure() {
+	if b.threshold == 0 {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+// Steps applied to a circuit breaker in turn
+const (
+	stepAllow   = "allow"
+	stepDeny    = "deny"
+	stepSuccess = "success"
+	stepFailure = "failure"
+	// The reset timeout passes
+	stepWait = "wait"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold uint
+		steps     []string
+		state     string
+	}{
+		{
+			name:      "disabled",
+			threshold: 0,
+			steps:     []string{stepFailure, stepFailure, stepFailure, stepAllow},
+			state:     breakerClosed,
+		},
+		{
+			name:      "failures below the threshold",
+			threshold: 3,
+			steps:     []string{stepAllow, stepFailure, stepAllow, stepFailure, stepAllow},
+			state:     breakerClosed,
+		},
+		{
+			name:      "success resets the failure count",
+			threshold: 2,
+			steps:     []string{stepFailure, stepSuccess, stepFailure, stepAllow},
+			state:     breakerClosed,
+		},
+		{
+			name:      "opens at the threshold",
+			threshold: 2,
+			steps:     []string{stepFailure, stepFailure, stepDeny, stepDeny},
+			state:     breakerOpen,
+		},
+		{
+			name:      "single trial request once the reset timeout passes",
+			threshold: 1,
+			steps:     []string{stepFailure, stepDeny, stepWait, stepAllow, stepDeny, stepDeny},
+			state:     breakerHalfOpen,
+		},
+		{
+			name:      "successful trial closes",
+			threshold: 1,
+			steps:     []string{stepFailure, stepWait, stepAllow, stepSuccess, stepAllow, stepAllow},
+			state:     breakerClosed,
+		},
+		{
+			name:      "failed trial reopens",
+			threshold: 3,
+			steps:     []string{stepFailure, stepFailure, stepFailure, stepWait, stepAllow, stepFailure, stepDeny},
+			state:     breakerOpen,
+		},
+		{
+			name:      "reopened breaker allows another trial",
+			threshold: 1,
+			steps:     []string{stepFailure, stepWait, stepAllow, stepFailure, stepDeny, stepWait, stepAllow, stepSuccess},
+			state:     breakerClosed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newCircuitBreaker(test.threshold, time.Hour)
+			for i, step := range test.steps {
+				switch step {
+				case stepAllow, stepDeny:
+					if allowed := b.allow(); allowed != (step == stepAllow) {
+						t.Fatalf("step %d: allow = %v in state %s", i, allowed, b.currentState())
+					}
+				case stepSuccess:
+					b.recordSuccess()
+				case stepFailure:
+					b.recordFailure()
+				case stepWait:
+					b.lock.Lock()
+					b.openedAt = b.openedAt.Add(-b.resetTimeout)
+					b.lock.Unlock()
+				}
+			}
+			if state := b.currentState(); state != test.state {
+				t.Errorf("state = %s, want %s", state, test.state)
+			}
+		})
+	}
+}
@@ -1,98 +1,336 @@
 package client
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
+	"github.com/MAKLs/nextcloud-exporter/config"
 	"github.com/MAKLs/nextcloud-exporter/metrics"
 	"github.com/MAKLs/nextcloud-exporter/models"
+	"github.com/MAKLs/nextcloud-exporter/version"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/http/httpproxy"
 )
 
 const (
-	authHeader = "NC-Token"
-	ncApi      = "/ocs/v2.php/apps/serverinfo/api/v1/info?format=json"
+	authHeader      = "NC-Token"
+	userAgentHeader = "User-Agent"
+	hostHeader      = "Host"
 )
 
+// Identifies exporter traffic in Nextcloud access logs
+var userAgent = fmt.Sprintf("nextcloud-exporter/%s (+https://github.com/MAKLs/nextcloud-exporter)", version.Version)
+
 type Client interface {
 	FetchNCServerInfo() (*models.NCServerInfo, error)
+	// Host the client fetches server info from
+	Target() string
+	// Current state of the circuit breaker towards the target
+	BreakerState() string
 }
 
 type NCClient struct {
 	httpClient *http.Client
 	url        *url.URL
-	token      string
+	// Index of the endpoint that last worked for this target
+	endpoint atomic.Int32
+	// When every endpoint last failed, in Unix nanoseconds, 0 if they haven't
+	fallbackFailedAt atomic.Int64
+	token            string
+	headers          map[string]string
+	timeout          time.Duration
+	retries          uint
+	initialBackoff   time.Duration
+	maxBackoff       time.Duration
+	breaker          *circuitBreaker
+	strictDecode     bool
 }
 
-func NewNCClient(baseUrl *url.URL, token string) *NCClient {
-	client := http.DefaultClient
-	if apiUrl, err := baseUrl.Parse(ncApi); err != nil {
-		panic(fmt.Sprintf("failed to parse URL: %v", err))
-	} else {
-		return &NCClient{httpClient: client, url: apiUrl, token: token}
+// Proxy function honouring the configured proxy and no_proxy hosts.
+// Without a configured proxy, the environment's proxy settings apply
+func proxyFunc(conf *config.Config) func(*http.Request) (*url.URL, error) {
+	if conf.ProxyUrl.Host == "" {
+		return http.ProxyFromEnvironment
+	}
+
+	proxyConfig := httpproxy.Config{
+		HTTPProxy:  conf.ProxyUrl.String(),
+		HTTPSProxy: conf.ProxyUrl.String(),
+		NoProxy:    strings.Join(conf.NoProxy, ","),
+	}
+	proxy := proxyConfig.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
 	}
 }
 
-func (c *NCClient) prepareRequest() (*http.Request, error) {
-	req, err := http.NewRequest(http.MethodGet, c.url.String(), nil)
+func NewNCClient(conf *config.Config) *NCClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxyFunc(conf)
+	client := &http.Client{Transport: transport}
+	for _, endpoint := range endpoints {
+		if _, err := conf.Url.Parse(endpoint.path); err != nil {
+			panic(fmt.Sprintf("failed to parse URL: %v", err))
+		}
+	}
+
+	baseUrl := conf.Url
+	return &NCClient{
+		httpClient:     client,
+		url:            &baseUrl,
+		token:          conf.Token,
+		headers:        conf.Headers,
+		timeout:        conf.Timeout,
+		retries:        conf.Retries,
+		initialBackoff: conf.RetryInitialBackoff,
+		maxBackoff:     conf.RetryMaxBackoff,
+		breaker:        newCircuitBreaker(conf.BreakerThreshold, conf.BreakerResetTimeout),
+		strictDecode:   conf.StrictDecode,
+	}
+}
+
+func (c *NCClient) Target() string {
+	return c.url.Host
+}
+
+func (c *NCClient) BreakerState() string {
+	return c.breaker.currentState()
+}
+
+func (c *NCClient) prepareRequest(ctx context.Context, endpoint endpoint) (*http.Request, error) {
+	apiUrl, err := c.url.Parse(endpoint.path)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiUrl.String(), nil)
 	if err != nil {
 		return nil, err
 	}
 
+	req.Header.Set(userAgentHeader, userAgent)
+	for name, value := range c.headers {
+		// The host of a request isn't set through its headers
+		if http.CanonicalHeaderKey(name) == hostHeader {
+			req.Host = value
+		} else {
+			req.Header.Set(name, value)
+		}
+	}
 	req.Header.Set(authHeader, c.token)
 
 	return req, nil
 }
 
+// Time a request to Nextcloud, attaching the target and status code as an exemplar
+func (c *NCClient) observeDuration(start time.Time, statusCode *int) {
+	exemplar := prometheus.Labels{"target": c.url.Host}
+	if *statusCode != 0 {
+		exemplar["status_code"] = strconv.Itoa(*statusCode)
+	}
+
+	duration := time.Since(start).Seconds()
+	// Exemplars over the rune limit make the observation panic, which long hostnames can reach
+	if observer, ok := metrics.ScrapeDuration.(prometheus.ExemplarObserver); ok && exemplarRunes(exemplar) <= prometheus.ExemplarMaxRunes {
+		observer.ObserveWithExemplar(duration, exemplar)
+	} else {
+		metrics.ScrapeDuration.Observe(duration)
+	}
+}
+
+// Count the runes in the names and values of exemplar labels
+func exemplarRunes(labels prometheus.Labels) int {
+	runes := 0
+	for name, value := range labels {
+		runes += utf8.RuneCountInString(name) + utf8.RuneCountInString(value)
+	}
+	return runes
+}
+
 func (c *NCClient) FetchNCServerInfo() (*models.NCServerInfo, error) {
-	req, err := c.prepareRequest()
+	// Skipped requests are neither timed nor counted as errors
+	if !c.breaker.allow() {
+		return nil, &ScrapeError{Stage: metrics.StageRequest, Reason: ReasonCircuitOpen, Err: fmt.Errorf("circuit breaker open, skipping request to %s", c.url.Host)}
+	}
+
+	var statusCode int
+	defer c.observeDuration(time.Now(), &statusCode)
+
+	ctx := context.Background()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	// Retry transient failures within the deadline
+	var (
+		result *models.NCServerInfo
+		err    error
+	)
+	for attempt := uint(0); ; attempt++ {
+		result, err = c.fetchOnce(ctx, &statusCode)
+		if err == nil || attempt >= c.retries || !retryable(err) {
+			break
+		}
+
+		wait := backoff(attempt, c.initialBackoff, c.maxBackoff)
+		log.Printf("retrying in %s after transient error: %v", wait, err)
+		if !sleepContext(ctx, wait) {
+			break
+		}
+	}
+
+	// Counted once per scrape, however many attempts it took
 	if err != nil {
-		return nil, err
+		c.breaker.recordFailure()
+		countScrapeError(err)
+	} else {
+		c.breaker.recordSuccess()
+	}
+
+	return result, err
+}
+
+// Check whether the other endpoints should be tried.
+// After they all failed, they aren't tried again until the probe interval has passed
+func (c *NCClient) shouldProbeFallback() bool {
+	failedAt := c.fallbackFailedAt.Load()
+	return failedAt == 0 || time.Since(time.Unix(0, failedAt)) >= fallbackProbeInterval
+}
+
+// Fetch server info from the endpoint that last worked.
+// If it fails in a way another endpoint may not, the others are tried in order
+// and the first to succeed is remembered
+func (c *NCClient) fetchOnce(ctx context.Context, statusCode *int) (*models.NCServerInfo, error) {
+	current := int(c.endpoint.Load())
+	result, err := c.fetchFromEndpoint(ctx, endpoints[current], statusCode)
+	if err == nil || !shouldFallback(err) || !c.shouldProbeFallback() {
+		return result, err
+	}
+
+	for i, endpoint := range endpoints {
+		if i == current {
+			continue
+		}
+
+		fallbackResult, fallbackErr := c.fetchFromEndpoint(ctx, endpoint, statusCode)
+		if fallbackErr == nil {
+			log.Printf("%s endpoint failed for %s, using %s endpoint instead", endpoints[current].name, c.url.Host, endpoint.name)
+			c.endpoint.Store(int32(i))
+			c.fallbackFailedAt.Store(0)
+			return fallbackResult, nil
+		}
+		if ctx.Err() != nil {
+			// Not every endpoint was tried
+			return result, err
+		}
+	}
+
+	log.Printf("no serverinfo endpoint works for %s, trying again in %s", c.url.Host, fallbackProbeInterval)
+	c.fallbackFailedAt.Store(time.Now().UnixNano())
+
+	// Report why the preferred endpoint failed
+	return result, err
+}
+
+// Make a single request for server info
+func (c *NCClient) fetchFromEndpoint(ctx context.Context, endpoint endpoint, statusCode *int) (*models.NCServerInfo, error) {
+	req, err := c.prepareRequest(ctx, endpoint)
+	if err != nil {
+		return nil, newScrapeError(metrics.StageRequest, ReasonUnknown, err)
 	}
 
 	res, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, newScrapeError(metrics.StageRequest, transportErrorReason(err), err)
 	}
 	defer res.Body.Close()
+	*statusCode = res.StatusCode
 
 	decodedBody, err := io.ReadAll(res.Body)
 	if err != nil {
-		return nil, err
+		return nil, newScrapeError(metrics.StageRequest, transportErrorReason(err), err)
 	}
 
 	// Handle response
 	var (
 		result    *models.NCServerInfo
 		errResult error
+		// Empty if the response couldn't be decoded
+		ocsStatusCode string
 	)
 
-	metrics.ScrapeCount.WithLabelValues(strconv.FormatUint(uint64(res.StatusCode), 10)).Inc()
-
 	switch res.StatusCode {
 	case http.StatusOK:
-		var ncMetrics models.NCServerInfo
-		err = json.Unmarshal(decodedBody, &ncMetrics)
+		// OCS can report failure with HTTP 200, with data that doesn't match the models,
+		// so its status is checked before decoding the rest
+		var (
+			ncStatus  models.NCError
+			ncMetrics models.NCServerInfo
+		)
+		if err = endpoint.decode(decodedBody, &ncStatus); err == nil {
+			ocsStatusCode = strconv.FormatUint(ncStatus.Ocs.Meta.StatusCode, 10)
+			if meta := ncStatus.Ocs.Meta; !meta.IsOK() {
+				result = nil
+				errResult = newScrapeError(metrics.StageHTTP, ReasonOCSFailure, fmt.Errorf("error fetching NC metrics: OCS status %d: %s", meta.StatusCode, meta.Message))
+				break
+			}
+			err = endpoint.decode(decodedBody, &ncMetrics)
+		}
+
 		if err != nil {
 			result = nil
-			errResult = err
+			errResult = newScrapeError(metrics.StageDecode, endpoint.invalidReason, err)
 		} else {
+			ncMetrics.Raw = decodedBody
+			if c.strictDecode {
+				c.recordUnmapped(endpoint, decodedBody, &ncMetrics)
+			}
 			result = &ncMetrics
 			errResult = nil
 		}
 	default:
 		var ncError models.NCError
 		result = nil
-		err = json.Unmarshal(decodedBody, &ncError)
+		err = endpoint.decode(decodedBody, &ncError)
 		if err != nil {
-			errResult = err
+			err = fmt.Errorf("error fetching NC metrics: %s", res.Status)
 		} else {
-			errResult = fmt.Errorf("error fetching NC metrics: %s", ncError.Ocs.Meta.Message)
+			ocsStatusCode = strconv.FormatUint(ncError.Ocs.Meta.StatusCode, 10)
+			err = fmt.Errorf("error fetching NC metrics: %s", ncError.Ocs.Meta.Message)
 		}
+		scrapeErr := newScrapeError(metrics.StageHTTP, responseErrorReason(res), err)
+		scrapeErr.StatusCode = res.StatusCode
+		errResult = scrapeErr
 	}
 
+	metrics.ScrapeCount.WithLabelValues(strconv.FormatUint(uint64(res.StatusCode), 10), ocsStatusCode).Inc()
+
 	return result, errResult
 }
+
+// Record the fields of a response that aren't mapped to any model.
+// Detection is diagnostic only, so failures don't fail the scrape
+func (c *NCClient) recordUnmapped(endpoint endpoint, body []byte, info *models.NCServerInfo) {
+	if endpoint.unmapped == nil {
+		return
+	}
+
+	unmapped, err := endpoint.unmapped(body)
+	if err != nil {
+		log.Printf("failed to detect unmapped fields: %v", err)
+		return
+	}
+	info.Unmapped = unmapped
+	metrics.UnmappedFields.Set(float64(len(unmapped)))
+}
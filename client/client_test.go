@@ -0,0 +1,60 @@
+package client
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MAKLs/nextcloud-exporter/metrics"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func lastScrapeDuration(t *testing.T) *dto.Histogram {
+	t.Helper()
+
+	var m dto.Metric
+	if err := metrics.ScrapeDuration.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram()
+}
+
+func TestObserveDurationExemplar(t *testing.T) {
+	tests := []struct {
+		name         string
+		host         string
+		wantExemplar bool
+	}{
+		{"short host", "cloud.example.com", true},
+		// A valid hostname whose exemplar labels go over the rune limit
+		{"long host", strings.Repeat("a", 60) + "." + strings.Repeat("b", 60), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &NCClient{url: &url.URL{Scheme: "https", Host: test.host}}
+			before := lastScrapeDuration(t).GetSampleCount()
+
+			statusCode := 200
+			c.observeDuration(time.Now(), &statusCode)
+
+			histogram := lastScrapeDuration(t)
+			if count := histogram.GetSampleCount(); count != before+1 {
+				t.Fatalf("sample count = %d, want %d", count, before+1)
+			}
+
+			found := false
+			for _, bucket := range histogram.GetBucket() {
+				for _, label := range bucket.GetExemplar().GetLabel() {
+					if label.GetName() == "target" && label.GetValue() == test.host {
+						found = true
+					}
+				}
+			}
+			if found != test.wantExemplar {
+				t.Errorf("exemplar for %s found = %v, want %v", test.host, found, test.wantExemplar)
+			}
+		})
+	}
+}
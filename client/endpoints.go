@@ -0,0 +1,68 @@
+package client
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"time"
+
+	"github.com/MAKLs/nextcloud-exporter/models"
+)
+
+const serverInfoPath = "apps/serverinfo/api/v1/info"
+
+// How long to stick with the preferred endpoint after every endpoint failed.
+// A disabled serverinfo app fails everywhere, so probing them all on every scrape is wasted
+const fallbackProbeInterval = 10 * time.Minute
+
+// Serverinfo API endpoint along with the decoder for its response format
+type endpoint struct {
+	name string
+	path string
+	// Reason reported when a response can't be decoded
+	invalidReason string
+	decode        func(data []byte, v interface{}) error
+	// Finds response fields missing from the models, nil if unsupported by the format
+	unmapped func(data []byte) ([]string, error)
+}
+
+// Endpoints in order of preference.
+// XML is the default format, so those endpoints work even when query strings are stripped
+var endpoints = []endpoint{
+	{
+		name:          "OCS v2 JSON",
+		path:          "/ocs/v2.php/" + serverInfoPath + "?format=json",
+		invalidReason: ReasonInvalidJSON,
+		decode:        json.Unmarshal,
+		unmapped:      models.UnmappedFields,
+	},
+	{
+		name:          "OCS v1 JSON",
+		path:          "/ocs/v1.php/" + serverInfoPath + "?format=json",
+		invalidReason: ReasonInvalidJSON,
+		decode:        json.Unmarshal,
+		unmapped:      models.UnmappedFields,
+	},
+	{
+		name:          "OCS v2 XML",
+		path:          "/ocs/v2.php/" + serverInfoPath,
+		invalidReason: ReasonInvalidXML,
+		decode:        xml.Unmarshal,
+	},
+	{
+		name:          "OCS v1 XML",
+		path:          "/ocs/v1.php/" + serverInfoPath,
+		invalidReason: ReasonInvalidXML,
+		decode:        xml.Unmarshal,
+	},
+}
+
+// Check whether a failure may be resolved by trying another endpoint.
+// Authentication, server and transport failures would fail the same way everywhere
+func shouldFallback(err error) bool {
+	switch ErrorReason(err) {
+	case ReasonInvalidJSON, ReasonInvalidXML, ReasonHTTP:
+		return true
+	default:
+		return false
+	}
+}
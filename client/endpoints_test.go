@@ -0,0 +1,164 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MAKLs/nextcloud-exporter/config"
+)
+
+const (
+	v2JSON = "/ocs/v2.php/" + serverInfoPath + "?format=json"
+	v1JSON = "/ocs/v1.php/" + serverInfoPath + "?format=json"
+	v2XML  = "/ocs/v2.php/" + serverInfoPath
+	v1XML  = "/ocs/v1.php/" + serverInfoPath
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("..", "models", "testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Stand-in Nextcloud answering through handle, recording the URI of every request
+type fakeNextcloud struct {
+	lock     sync.Mutex
+	requests []string
+	handle   func(r *http.Request) (int, []byte)
+}
+
+func newFakeNextcloud(t *testing.T, handle func(r *http.Request) (int, []byte)) (*fakeNextcloud, *NCClient) {
+	t.Helper()
+
+	nc := &fakeNextcloud{handle: handle}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nc.lock.Lock()
+		nc.requests = append(nc.requests, r.URL.RequestURI())
+		nc.lock.Unlock()
+
+		status, body := handle(r)
+		w.WriteHeader(status)
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+
+	serverUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nc, NewNCClient(&config.Config{Url: *serverUrl, Timeout: 5 * time.Second})
+}
+
+// Requests made since the last call
+func (nc *fakeNextcloud) takeRequests() []string {
+	nc.lock.Lock()
+	defer nc.lock.Unlock()
+
+	requests := nc.requests
+	nc.requests = nil
+	return requests
+}
+
+func expectRequests(t *testing.T, nc *fakeNextcloud, want ...string) {
+	t.Helper()
+
+	got := nc.takeRequests()
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+}
+
+func TestFallbackToV1(t *testing.T) {
+	body := readFixture(t, "nc28.json")
+	nc, c := newFakeNextcloud(t, func(r *http.Request) (int, []byte) {
+		if strings.HasPrefix(r.URL.Path, "/ocs/v2.php/") {
+			return http.StatusNotFound, []byte("Not Found")
+		}
+		return http.StatusOK, body
+	})
+
+	info, err := c.FetchNCServerInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := info.Ocs.Data.NextCloud.System.Version; version != "28.0.4.1" {
+		t.Errorf("version = %q, want 28.0.4.1", version)
+	}
+	expectRequests(t, nc, v2JSON, v1JSON)
+
+	// The endpoint that worked is remembered
+	if _, err := c.FetchNCServerInfo(); err != nil {
+		t.Fatal(err)
+	}
+	expectRequests(t, nc, v1JSON)
+}
+
+func TestFallbackToXMLWhenQueryIsStripped(t *testing.T) {
+	body := readFixture(t, "nc28.xml")
+	// A proxy strips the query string, so every endpoint answers in the default XML format
+	nc, c := newFakeNextcloud(t, func(r *http.Request) (int, []byte) {
+		return http.StatusOK, body
+	})
+
+	info, err := c.FetchNCServerInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := info.Ocs.Data.NextCloud.System.Version; version != "28.0.4.1" {
+		t.Errorf("version = %q, want 28.0.4.1", version)
+	}
+	expectRequests(t, nc, v2JSON, v1JSON, v2XML)
+
+	if _, err := c.FetchNCServerInfo(); err != nil {
+		t.Fatal(err)
+	}
+	expectRequests(t, nc, v2XML)
+}
+
+func TestFallbackProbeSuppressed(t *testing.T) {
+	nc, c := newFakeNextcloud(t, func(r *http.Request) (int, []byte) {
+		return http.StatusNotFound, []byte("Not Found")
+	})
+
+	_, err := c.FetchNCServerInfo()
+	if reason := ErrorReason(err); reason != ReasonHTTP {
+		t.Fatalf("reason = %s, want %s (%v)", reason, ReasonHTTP, err)
+	}
+	expectRequests(t, nc, v2JSON, v1JSON, v2XML, v1XML)
+
+	// After every endpoint failed, only the preferred one is tried until the probe interval passes
+	if _, err := c.FetchNCServerInfo(); err == nil {
+		t.Fatal("expected an error")
+	}
+	expectRequests(t, nc, v2JSON)
+
+	c.fallbackFailedAt.Store(time.Now().Add(-fallbackProbeInterval).UnixNano())
+	if _, err := c.FetchNCServerInfo(); err == nil {
+		t.Fatal("expected an error")
+	}
+	expectRequests(t, nc, v2JSON, v1JSON, v2XML, v1XML)
+}
+
+func TestNoFallbackOnAuthFailure(t *testing.T) {
+	nc, c := newFakeNextcloud(t, func(r *http.Request) (int, []byte) {
+		return http.StatusUnauthorized, []byte(`{"ocs":{"meta":{"status":"failure","statuscode":997,"message":"Unauthorised"},"data":[]}}`)
+	})
+
+	_, err := c.FetchNCServerInfo()
+	if reason := ErrorReason(err); reason != ReasonAuth {
+		t.Errorf("reason = %s, want %s (%v)", reason, ReasonAuth, err)
+	}
+	// Every endpoint would fail the same way
+	expectRequests(t, nc, v2JSON)
+}
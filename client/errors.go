@@ -0,0 +1,129 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/MAKLs/nextcloud-exporter/metrics"
+)
+
+// Reasons a scrape can fail
+const (
+	ReasonDNS               = "dns"
+	ReasonConnectionRefused = "connection_refused"
+	ReasonTLS               = "tls"
+	ReasonTimeout           = "timeout"
+	ReasonAuth              = "auth"
+	ReasonServerError       = "server_error"
+	ReasonMaintenance       = "maintenance"
+	ReasonHTTP              = "http"
+	ReasonInvalidJSON       = "invalid_json"
+	ReasonInvalidXML        = "invalid_xml"
+	ReasonOCSFailure        = "ocs_failure"
+	ReasonCircuitOpen       = "circuit_open"
+	ReasonUnknown           = "unknown"
+)
+
+var ScrapeErrorReasons = []string{
+	ReasonDNS,
+	ReasonConnectionRefused,
+	ReasonTLS,
+	ReasonTimeout,
+	ReasonAuth,
+	ReasonServerError,
+	ReasonMaintenance,
+	ReasonHTTP,
+	ReasonInvalidJSON,
+	ReasonInvalidXML,
+	ReasonOCSFailure,
+	ReasonCircuitOpen,
+	ReasonUnknown,
+}
+
+// Header set by Nextcloud while in maintenance mode
+const maintenanceHeader = "X-Nextcloud-Maintenance-Mode"
+
+// Error returned when fetching server info fails, categorised by reason
+type ScrapeError struct {
+	Stage  string
+	Reason string
+	// HTTP status code, or 0 if no response was received
+	StatusCode int
+	Err        error
+}
+
+func (e *ScrapeError) Error() string {
+	return fmt.Sprintf("%s error (%s): %v", e.Stage, e.Reason, e.Err)
+}
+
+func (e *ScrapeError) Unwrap() error {
+	return e.Err
+}
+
+// Wrap an error with its stage and reason
+func newScrapeError(stage string, reason string, err error) *ScrapeError {
+	return &ScrapeError{Stage: stage, Reason: reason, Err: err}
+}
+
+// Count a failed scrape towards the errors at the stage it failed
+func countScrapeError(err error) {
+	var scrapeErr *ScrapeError
+	if errors.As(err, &scrapeErr) {
+		metrics.ScrapeErrors.WithLabelValues(scrapeErr.Stage).Inc()
+	}
+}
+
+// Reason for a scrape error, or `ReasonUnknown` if it isn't a `ScrapeError`
+func ErrorReason(err error) string {
+	var scrapeErr *ScrapeError
+	if errors.As(err, &scrapeErr) {
+		return scrapeErr.Reason
+	}
+	return ReasonUnknown
+}
+
+// Categorise errors from the transport, before a response is received
+func transportErrorReason(err error) string {
+	var (
+		dnsErr       *net.DNSError
+		netErr       net.Error
+		recordErr    tls.RecordHeaderError
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return ReasonDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ReasonConnectionRefused
+	case errors.As(err, &recordErr), errors.As(err, &verifyErr), errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return ReasonTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ReasonTimeout
+	default:
+		return ReasonUnknown
+	}
+}
+
+// Categorise unsuccessful HTTP responses
+func responseErrorReason(res *http.Response) string {
+	switch {
+	case res.Header.Get(maintenanceHeader) == "1":
+		return ReasonMaintenance
+	case res.StatusCode == http.StatusUnauthorized, res.StatusCode == http.StatusForbidden:
+		return ReasonAuth
+	case res.StatusCode >= http.StatusInternalServerError:
+		return ReasonServerError
+	default:
+		return ReasonHTTP
+	}
+}
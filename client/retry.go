@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// Check whether a failed request is safe and worthwhile to retry.
+// Only transient failures are retried: dropped connections and gateway errors
+func retryable(err error) bool {
+	var scrapeErr *ScrapeError
+	if !errors.As(err, &scrapeErr) {
+		return false
+	}
+
+	switch scrapeErr.StatusCode {
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return true
+	case http.StatusServiceUnavailable:
+		return scrapeErr.Reason != ReasonMaintenance
+	case 0:
+		return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	default:
+		return false
+	}
+}
+
+// Exponential backoff with full jitter, capped at the max backoff
+func backoff(attempt uint, initial time.Duration, max time.Duration) time.Duration {
+	ceiling := initial << attempt
+	if ceiling <= 0 || ceiling > max {
+		ceiling = max
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// Wait before the next attempt.
+// Returns false if the context ends first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		// Don't start an attempt that can't finish before the deadline
+		return false
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/MAKLs/nextcloud-exporter/metrics"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"bad gateway", &ScrapeError{Reason: ReasonServerError, StatusCode: http.StatusBadGateway}, true},
+		{"gateway timeout", &ScrapeError{Reason: ReasonServerError, StatusCode: http.StatusGatewayTimeout}, true},
+		{"service unavailable", &ScrapeError{Reason: ReasonServerError, StatusCode: http.StatusServiceUnavailable}, true},
+		{"maintenance", &ScrapeError{Reason: ReasonMaintenance, StatusCode: http.StatusServiceUnavailable}, false},
+		{"internal server error", &ScrapeError{Reason: ReasonServerError, StatusCode: http.StatusInternalServerError}, false},
+		{"unauthorized", &ScrapeError{Reason: ReasonAuth, StatusCode: http.StatusUnauthorized}, false},
+		{"not found", &ScrapeError{Reason: ReasonHTTP, StatusCode: http.StatusNotFound}, false},
+		{"connection reset", newScrapeError(metrics.StageRequest, ReasonUnknown, &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), true},
+		{"unexpected EOF", newScrapeError(metrics.StageRequest, ReasonUnknown, fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF)), true},
+		{"EOF", newScrapeError(metrics.StageRequest, ReasonUnknown, io.EOF), true},
+		{"connection refused", newScrapeError(metrics.StageRequest, ReasonConnectionRefused, &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), false},
+		{"timeout", newScrapeError(metrics.StageRequest, ReasonTimeout, context.DeadlineExceeded), false},
+		{"decode", newScrapeError(metrics.StageDecode, ReasonInvalidJSON, errors.New("unexpected end of JSON input")), false},
+		{"not a scrape error", io.EOF, false},
+	}
+
+	for _, test := range tests {
+		if got := retryable(test.err); got != test.want {
+			t.Errorf("%s: retryable = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt uint
+		initial time.Duration
+		max     time.Duration
+		ceiling time.Duration
+	}{
+		{0, 100 * time.Millisecond, 2 * time.Second, 100 * time.Millisecond},
+		{1, 100 * time.Millisecond, 2 * time.Second, 200 * time.Millisecond},
+		{3, 100 * time.Millisecond, 2 * time.Second, 800 * time.Millisecond},
+		// Capped at the max backoff
+		{5, 100 * time.Millisecond, 2 * time.Second, 2 * time.Second},
+		// Shifting overflows
+		{70, 100 * time.Millisecond, 2 * time.Second, 2 * time.Second},
+		{0, 0, 0, 0},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			wait := backoff(test.attempt, test.initial, test.max)
+			if wait < 0 || (test.ceiling == 0 && wait != 0) || (test.ceiling > 0 && wait >= test.ceiling) {
+				t.Fatalf("backoff(%d, %s, %s) = %s, want within [0, %s)", test.attempt, test.initial, test.max, wait, test.ceiling)
+			}
+		}
+	}
+}
+
+func TestSleepContext(t *testing.T) {
+	if !sleepContext(context.Background(), time.Millisecond) {
+		t.Error("sleep without a deadline was cut short")
+	}
+
+	// An attempt that can't start before the deadline isn't waited for
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if sleepContext(ctx, time.Second) {
+		t.Error("sleep past the deadline succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("sleep past the deadline waited %s", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if sleepContext(cancelled, time.Second) {
+		t.Error("sleep with a cancelled context succeeded")
+	}
+}
@@ -2,9 +2,14 @@ package config
 
 import (
 	"fmt"
+	"log"
 	"net/url"
 	"reflect"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/MAKLs/nextcloud-exporter/metrics"
 	"github.com/fsnotify/fsnotify"
 	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
@@ -18,17 +23,89 @@ const (
 
 var (
 	exporterConfig *Config
-	configPaths    = []string{"."}
-	defaults       = map[string]interface{}{
-		"port":            9205,
-		"token":           "",
-		"url":             "http://localhost/",
-		"exclude_php":     false,
-		"exclude_strings": false,
-		"filter":          []string{},
+	// Why the last reload failed, nil if it succeeded
+	reloadError error
+	configLock  sync.RWMutex
+	configPaths = []string{"."}
+	defaults    = map[string]interface{}{
+		"port":                              9205,
+		"token":                             "",
+		"url":                               "http://localhost/",
+		"exclude_php":                       false,
+		"exclude_strings":                   false,
+		"filter":                            []string{},
+		"derived_metrics":                   false,
+		"include":                           []string{},
+		"exclude":                           []string{},
+		"relabel":                           []map[string]interface{}{},
+		"labels":                            map[string]string{},
+		"legacy_string_metrics":             false,
+		"timeout":                           10 * time.Second,
+		"retries":                           0,
+		"retry_initial_backoff":             100 * time.Millisecond,
+		"retry_max_backoff":                 2 * time.Second,
+		"breaker_threshold":                 0,
+		"breaker_reset_timeout":             30 * time.Second,
+		"min_interval":                      time.Duration(0),
+		"proxy_url":                         "",
+		"no_proxy":                          []string{},
+		"headers":                           map[string]string{},
+		"strict_decode":                     false,
+		"tls_server_config":                 map[string]interface{}{},
+		"basic_auth_users":                  map[string]string{},
+		"ready_window":                      5 * time.Minute,
+		"listen_address":                    []string{},
+		"metrics_path":                      "/metrics",
+		"history_size":                      0,
+		"history_max_bytes":                 4 << 20,
+		"change_webhook_url":                "",
+		"notify_webhook_url":                "",
+		"notify_alertmanager_url":           "",
+		"notify_talk_url":                   "",
+		"notify_talk_token":                 "",
+		"notify_talk_secret":                "",
+		"notify_debounce":                   time.Minute,
+		"notify_repeat_interval":            time.Duration(0),
+		"push_gateway_url":                  "",
+		"push_job":                          "nextcloud",
+		"push_interval":                     time.Minute,
+		"push_grouping":                     map[string]string{},
+		"push_username":                     "",
+		"push_password":                     "",
+		"push_tls_config":                   map[string]interface{}{},
+		"remote_write_url":                  "",
+		"remote_write_interval":             time.Minute,
+		"remote_write_queue_capacity":       10000,
+		"remote_write_max_samples_per_send": 2000,
+		"remote_write_retries":              3,
+		"remote_write_retry_backoff":        500 * time.Millisecond,
+		"remote_write_timeout":              30 * time.Second,
+		"remote_write_username":             "",
+		"remote_write_password":             "",
+		"remote_write_tls_config":           map[string]interface{}{},
 	}
 )
 
+// Paths of the exporter's built-in endpoints
+const (
+	HealthzPath    = "/healthz"
+	HealthyPath    = "/-/healthy"
+	ReadyPath      = "/-/ready"
+	UnmappedPath   = "/debug/unmapped"
+	HistoryPath    = "/debug/history"
+	ServerInfoPath = "/api/v1/serverinfo"
+)
+
+// Built-in endpoints, which the metrics can't be served on
+var reservedPaths = []string{
+	HealthzPath,
+	HealthyPath,
+	ReadyPath,
+	UnmappedPath,
+	HistoryPath,
+	ServerInfoPath,
+}
+
 type Config struct {
 	Port           uint     `mapstructure:"port"`
 	Url            url.URL  `mapstructure:"url"`
@@ -36,19 +113,156 @@ type Config struct {
 	FilterMetrics  []string `mapstructure:"filter"`
 	ExcludePHP     bool     `mapstructure:"exclude_php"`
 	ExcludeStrings bool     `mapstructure:"exclude_strings"`
+	// Emit string fields as separate series alongside info metrics
+	LegacyStringMetrics bool `mapstructure:"legacy_string_metrics"`
+	DerivedMetrics      bool `mapstructure:"derived_metrics"`
+	// Regexes matched against fully-qualified metric names
+	IncludeMetrics []string        `mapstructure:"include"`
+	ExcludeMetrics []string        `mapstructure:"exclude"`
+	RelabelRules   []RelabelConfig `mapstructure:"relabel"`
+	// Const labels attached to every exported metric
+	Labels map[string]string `mapstructure:"labels"`
+	// Deadline for fetching server info, including retries
+	Timeout             time.Duration `mapstructure:"timeout"`
+	Retries             uint          `mapstructure:"retries"`
+	RetryInitialBackoff time.Duration `mapstructure:"retry_initial_backoff"`
+	RetryMaxBackoff     time.Duration `mapstructure:"retry_max_backoff"`
+	// Consecutive failures before requests are suspended, 0 disables the breaker
+	BreakerThreshold    uint          `mapstructure:"breaker_threshold"`
+	BreakerResetTimeout time.Duration `mapstructure:"breaker_reset_timeout"`
+	// Scrapes within this interval of the last request reuse its result
+	MinInterval time.Duration `mapstructure:"min_interval"`
+	// Proxy for requests to Nextcloud, defaults to the environment's proxy
+	ProxyUrl url.URL `mapstructure:"proxy_url"`
+	// Hosts to reach without the proxy, in the format of NO_PROXY
+	NoProxy []string `mapstructure:"no_proxy"`
+	// Extra headers sent with requests to Nextcloud
+	Headers map[string]string `mapstructure:"headers"`
+	// Record response fields that aren't mapped to any metric.
+	// They're served at /debug/unmapped when basic auth is configured
+	StrictDecode bool `mapstructure:"strict_decode"`
+	// Web config for the exporter's own endpoints, in the style of the Prometheus exporter-toolkit
+	TLSServerConfig TLSServerConfig `mapstructure:"tls_server_config"`
+	// Bcrypt-hashed passwords by username
+	BasicAuthUsers map[string]string `mapstructure:"basic_auth_users"`
+	// The exporter is ready once server info was fetched successfully within this window
+	ReadyWindow time.Duration `mapstructure:"ready_window"`
+	// Addresses to serve on, as host:port, `unix:<path>` or `systemd`.
+	// Defaults to the port on every interface
+	ListenAddresses []string `mapstructure:"listen_address"`
+	MetricsPath     string   `mapstructure:"metrics_path"`
+	// Snapshots of server info kept in memory for debugging, 0 disables the history.
+	// They're served at /debug/history when basic auth is configured
+	HistorySize     uint `mapstructure:"history_size"`
+	HistoryMaxBytes uint `mapstructure:"history_max_bytes"`
+	// Detected changes are POSTed here as JSON, if set
+	ChangeWebhookUrl url.URL `mapstructure:"change_webhook_url"`
+	// Notifications when the target goes down or recovers, each enabled by setting its URL
+	NotifyWebhookUrl      url.URL `mapstructure:"notify_webhook_url"`
+	NotifyAlertmanagerUrl url.URL `mapstructure:"notify_alertmanager_url"`
+	// Nextcloud instance hosting the Talk room, its token and the bot's shared secret
+	NotifyTalkUrl    url.URL `mapstructure:"notify_talk_url"`
+	NotifyTalkToken  string  `mapstructure:"notify_talk_token"`
+	NotifyTalkSecret string  `mapstructure:"notify_talk_secret"`
+	// How long a state must persist before notifying, and how often to repeat while down (0 disables)
+	NotifyDebounce       time.Duration `mapstructure:"notify_debounce"`
+	NotifyRepeatInterval time.Duration `mapstructure:"notify_repeat_interval"`
+	// Push metrics to a Pushgateway on an interval, if its URL is set.
+	// The group is keyed by the target's host as `instance`, plus any extra labels
+	PushGatewayUrl url.URL           `mapstructure:"push_gateway_url"`
+	PushJob        string            `mapstructure:"push_job"`
+	PushInterval   time.Duration     `mapstructure:"push_interval"`
+	PushGrouping   map[string]string `mapstructure:"push_grouping"`
+	PushUsername   string            `mapstructure:"push_username"`
+	PushPassword   string            `mapstructure:"push_password"`
+	PushTLSConfig  TLSClientConfig   `mapstructure:"push_tls_config"`
+	// Send metrics through the Prometheus remote_write protocol on an interval, if its URL is set
+	RemoteWriteUrl      url.URL       `mapstructure:"remote_write_url"`
+	RemoteWriteInterval time.Duration `mapstructure:"remote_write_interval"`
+	// Samples buffered while the receiver is unavailable, the oldest are dropped beyond this
+	RemoteWriteQueueCapacity     uint            `mapstructure:"remote_write_queue_capacity"`
+	RemoteWriteMaxSamplesPerSend uint            `mapstructure:"remote_write_max_samples_per_send"`
+	RemoteWriteRetries           uint            `mapstructure:"remote_write_retries"`
+	RemoteWriteRetryBackoff      time.Duration   `mapstructure:"remote_write_retry_backoff"`
+	RemoteWriteTimeout           time.Duration   `mapstructure:"remote_write_timeout"`
+	RemoteWriteUsername          string          `mapstructure:"remote_write_username"`
+	RemoteWritePassword          string          `mapstructure:"remote_write_password"`
+	RemoteWriteTLSConfig         TLSClientConfig `mapstructure:"remote_write_tls_config"`
+}
+
+// Addresses to serve on, falling back to the port on every interface
+func (conf *Config) Listen() []string {
+	if len(conf.ListenAddresses) > 0 {
+		return conf.ListenAddresses
+	}
+	return []string{fmt.Sprintf(":%d", conf.Port)}
 }
 
+// TLS settings for connecting to a remote endpoint
+type TLSClientConfig struct {
+	CAFile             string `mapstructure:"ca_file"`
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+	ServerName         string `mapstructure:"server_name"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
+// TLS settings for serving the exporter's endpoints.
+// Without a certificate, endpoints are served over plain HTTP
+type TLSServerConfig struct {
+	CertFile       string `mapstructure:"cert_file"`
+	KeyFile        string `mapstructure:"key_file"`
+	ClientAuthType string `mapstructure:"client_auth_type"`
+	ClientCAFile   string `mapstructure:"client_ca_file"`
+	MinVersion     string `mapstructure:"min_version"`
+}
+
+// Relabel rule in the style of Prometheus `metric_relabel_configs`.
+// The metric name is available as the `__name__` label.
+// Rules apply to Nextcloud metrics only, the exporter's self-metrics are sent unchanged
+type RelabelConfig struct {
+	SourceLabels []string `mapstructure:"source_labels"`
+	Separator    string   `mapstructure:"separator"`
+	Regex        string   `mapstructure:"regex"`
+	TargetLabel  string   `mapstructure:"target_label"`
+	Replacement  string   `mapstructure:"replacement"`
+	Action       string   `mapstructure:"action"`
+}
+
+// Reload the config on changes, notifying the channel once it's loaded.
+// An invalid config is reported by ReloadError and the previous config is kept
 func Notify(ch chan<- fsnotify.Event) {
 	viper.OnConfigChange(func(in fsnotify.Event) {
-		mustUnmarshalConfig()
+		conf, err := unmarshalConfig()
+
+		configLock.Lock()
+		reloadError = err
+		if err == nil {
+			exporterConfig = conf
+		}
+		configLock.Unlock()
+
+		if err != nil {
+			log.Printf("keeping the previous config, failed to reload \"%s\": %v", in.Name, err)
+			return
+		}
 		ch <- in
 	})
 }
 
 func GetConfig() *Config {
+	configLock.RLock()
+	defer configLock.RUnlock()
 	return exporterConfig
 }
 
+// Error from the last attempt to reload the config, nil if it's in effect
+func ReloadError() error {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return reloadError
+}
+
 func decoderConfig(config *mapstructure.DecoderConfig) {
 	config.ZeroFields = true
 	config.ErrorUnused = true
@@ -64,10 +278,44 @@ func urlFromStringHookFunc() mapstructure.DecodeHookFuncType {
 	}
 }
 
+func validateMetricsPath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("metrics_path '%s' must start with '/'", path)
+	}
+	for _, reserved := range reservedPaths {
+		if path == reserved {
+			return fmt.Errorf("metrics_path '%s' is already served by the exporter", path)
+		}
+	}
+	return nil
+}
+
+// Decode and validate the config into a new value,
+// so the config in use isn't modified while it's read
+func unmarshalConfig() (*Config, error) {
+	var conf Config
+	if err := viper.Unmarshal(&conf, decoderConfig, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		urlFromStringHookFunc(),
+		mapstructure.StringToTimeDurationHookFunc(),
+	))); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	if err := validateMetricsPath(conf.MetricsPath); err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+	if err := metrics.CheckConstLabels(conf.Labels); err != nil {
+		return nil, fmt.Errorf("invalid config: labels: %v", err)
+	}
+	return &conf, nil
+}
+
 func mustUnmarshalConfig() {
-	if err := viper.Unmarshal(&exporterConfig, decoderConfig, viper.DecodeHook(urlFromStringHookFunc())); err != nil {
-		panic(fmt.Sprintf("failed to parse config: %v", err))
+	conf, err := unmarshalConfig()
+	if err != nil {
+		panic(err.Error())
 	}
+	exporterConfig = conf
 }
 
 func init() {
@@ -0,0 +1,95 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/MAKLs/nextcloud-exporter/metrics"
+	"github.com/MAKLs/nextcloud-exporter/models"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// Change detected between consecutive server info responses
+type ChangeEvent struct {
+	Kind      string      `json:"kind"`
+	Target    string      `json:"target"`
+	Old       interface{} `json:"old"`
+	New       interface{} `json:"new"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Detectors of changes between the previous and current server info.
+// Each returns the old and new values if a change of its kind happened
+var changeDetectors = map[string]func(prev *models.Data, curr *models.Data) (interface{}, interface{}, bool){
+	metrics.ChangeVersion: func(prev *models.Data, curr *models.Data) (interface{}, interface{}, bool) {
+		before, after := prev.NextCloud.System.Version, curr.NextCloud.System.Version
+		return before, after, before != after
+	},
+	metrics.ChangeDebugEnabled: func(prev *models.Data, curr *models.Data) (interface{}, interface{}, bool) {
+		before, after := prev.NextCloud.System.Debug, curr.NextCloud.System.Debug
+		return before, after, bool(!before && after)
+	},
+	metrics.ChangePreviewsToggled: func(prev *models.Data, curr *models.Data) (interface{}, interface{}, bool) {
+		before, after := prev.NextCloud.System.EnablePreviews, curr.NextCloud.System.EnablePreviews
+		return before, after, before != after
+	},
+	metrics.ChangeOpcacheRestart: func(prev *models.Data, curr *models.Data) (interface{}, interface{}, bool) {
+		before, after := prev.Server.PHP.Opcache.OpcacheStatistics.LastRestartTime, curr.Server.PHP.Opcache.OpcacheStatistics.LastRestartTime
+		return before, after, before != after
+	},
+	metrics.ChangeAppUpdatesGrowth: func(prev *models.Data, curr *models.Data) (interface{}, interface{}, bool) {
+		before, after := prev.NextCloud.System.Apps.NumUpdatesAvailable, curr.NextCloud.System.Apps.NumUpdatesAvailable
+		return before, after, after > before
+	},
+}
+
+// Detect changes since the previous server info, counting each and notifying the webhook
+func (col *NCExporter) detectChanges(prev *models.NCServerInfo, curr *models.NCServerInfo) {
+	now := time.Now()
+	for _, kind := range metrics.ChangeKinds {
+		before, after, changed := changeDetectors[kind](&prev.Ocs.Data, &curr.Ocs.Data)
+		if !changed {
+			continue
+		}
+
+		metrics.ChangeEvents.WithLabelValues(kind).Inc()
+		metrics.LastChange.WithLabelValues(kind).Set(float64(now.Unix()))
+		log.Printf("detected %s on %s: %v -> %v", kind, col.client.Target(), before, after)
+
+		if col.changeWebhook.Host != "" {
+			go postChangeEvent(col.changeWebhook, ChangeEvent{
+				Kind:      kind,
+				Target:    col.client.Target(),
+				Old:       before,
+				New:       after,
+				Timestamp: now,
+			})
+		}
+	}
+}
+
+func postChangeEvent(webhook url.URL, event ChangeEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("failed to serialize change event: %v", err)
+		return
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	res, err := client.Post(webhook.String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("failed to post change event: %v", err)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		log.Println(fmt.Errorf("failed to post change event: %s", res.Status))
+	}
+}
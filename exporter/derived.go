@@ -0,0 +1,69 @@
+package exporter
+
+import (
+	"log"
+	"reflect"
+
+	"github.com/MAKLs/nextcloud-exporter/metrics"
+	"github.com/MAKLs/nextcloud-exporter/models"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Computes a derived value from server info.
+// Returns false if the value cannot be computed, e.g. division by zero
+type deriveFunc func(info *models.NCServerInfo) (float64, bool)
+
+// Derived metrics keyed by metric template name
+var derivedMetrics = map[string]deriveFunc{
+	"system_memory_utilization_ratio": func(info *models.NCServerInfo) (float64, bool) {
+		sys := info.Ocs.Data.NextCloud.System
+		return ratio(sys.MemTotal-sys.MemFree, sys.MemTotal)
+	},
+	"shares_link_no_password_ratio": func(info *models.NCServerInfo) (float64, bool) {
+		shares := info.Ocs.Data.NextCloud.Shares
+		return ratio(shares.NumSharesLinkNoPassword, shares.NumSharesLink)
+	},
+	"php_opcache_hit_ratio": func(info *models.NCServerInfo) (float64, bool) {
+		stats := info.Ocs.Data.Server.PHP.Opcache.OpcacheStatistics
+		return ratio(stats.Hits, stats.Hits+stats.Misses)
+	},
+	"php_opcache_memory_utilization_ratio": func(info *models.NCServerInfo) (float64, bool) {
+		usage := info.Ocs.Data.Server.PHP.Opcache.MemoryUsage
+		return ratio(usage.UsedMemory, usage.UsedMemory+usage.FreeMemory+usage.WastedMemory)
+	},
+	"php_opcache_interned_strings_utilization_ratio": func(info *models.NCServerInfo) (float64, bool) {
+		usage := info.Ocs.Data.Server.PHP.Opcache.InternedStringsUsage
+		return ratio(usage.UsedMemory, usage.BufferSize)
+	},
+	"php_apcu_cache_hit_ratio": func(info *models.NCServerInfo) (float64, bool) {
+		cache := info.Ocs.Data.Server.PHP.APCU.Cache
+		return ratio(cache.NumHits, cache.NumHits+cache.NumMisses)
+	},
+}
+
+func ratio(numerator models.Number, denominator models.Number) (float64, bool) {
+	if denominator == 0 {
+		return 0, false
+	}
+	return float64(numerator / denominator), true
+}
+
+// Emit metrics computed from raw server info fields
+func (col *NCExporter) collectDerivedMetrics(info *models.NCServerInfo, ch chan<- prometheus.Metric) {
+	for name, derive := range derivedMetrics {
+		if col.shouldSkipMetric(name, reflect.Float64) {
+			continue
+		}
+
+		value, ok := derive(info)
+		if !ok {
+			continue
+		}
+
+		if metricTemplate, ok := metrics.MetricsCollection.WithName(name); ok {
+			col.emitMetric(ch, metricTemplate, value)
+		} else {
+			log.Printf("%s derived for export but no corresponding metric template found", name)
+		}
+	}
+}
@@ -1,85 +1,201 @@
 package exporter
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net/url"
 	"reflect"
+	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/MAKLs/nextcloud-exporter/client"
+	"github.com/MAKLs/nextcloud-exporter/config"
 	"github.com/MAKLs/nextcloud-exporter/metrics"
-	"github.com/MAKLs/nextcloud-exporter/models"
+	"github.com/MAKLs/nextcloud-exporter/notify"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
 )
 
 type NCExporter struct {
-	client         client.Client
-	lock           sync.Mutex
-	excludePHP     bool
-	excludeStrings bool
-	filterMetrics  []string
+	client          client.Client
+	lock            sync.Mutex
+	excludePHP      bool
+	excludeStrings  bool
+	filterMetrics   []string
+	derive          bool
+	legacyStrings   bool
+	includeMetrics  []*regexp.Regexp
+	excludeMetrics  []*regexp.Regexp
+	relabelRules    []relabelRule
+	relabelledDescs map[string]*prometheus.Desc
+	strictDecode    bool
+	// Whether metrics are sent through remote_write, for its self-metrics
+	remoteWrite bool
+	// Upstream request coalescing and caching
+	fetchGroup  singleflight.Group
+	cacheLock   sync.Mutex
+	cached      *fetchResult
+	fetchedAt   time.Time
+	minInterval time.Duration
+	// Last server info fetched successfully
+	lastSnapshot *Snapshot
+	history      *history
+	// Receives changes detected between scrapes, if its host is set
+	changeWebhook url.URL
+	notifier      *notify.Notifier
+	// Outcome of upstream requests, for readiness
+	readyWindow   time.Duration
+	cancel        context.CancelFunc
+	lastDuration  time.Duration
+	lastError     error
+	lastErrorTime time.Time
 }
 
-func NewNCExporter(client client.Client, excludePHP bool, excludeStrings bool, filterMetrics []string) *NCExporter {
+func NewNCExporter(client client.Client, conf *config.Config) *NCExporter {
 	return &NCExporter{
-		client:         client,
-		excludePHP:     excludePHP,
-		excludeStrings: excludeStrings,
-		filterMetrics:  filterMetrics,
+		client:          client,
+		excludePHP:      conf.ExcludePHP,
+		excludeStrings:  conf.ExcludeStrings,
+		filterMetrics:   conf.FilterMetrics,
+		derive:          conf.DerivedMetrics,
+		legacyStrings:   conf.LegacyStringMetrics,
+		includeMetrics:  mustCompileAnchored(conf.IncludeMetrics),
+		excludeMetrics:  mustCompileAnchored(conf.ExcludeMetrics),
+		relabelRules:    mustCompileRelabelRules(conf.RelabelRules),
+		relabelledDescs: make(map[string]*prometheus.Desc),
+		minInterval:     conf.MinInterval,
+		strictDecode:    conf.StrictDecode,
+		remoteWrite:     conf.RemoteWriteUrl.Host != "",
+		readyWindow:     conf.ReadyWindow,
+		history:         newHistory(conf.HistorySize, conf.HistoryMaxBytes),
+		changeWebhook:   conf.ChangeWebhookUrl,
+		notifier:        notify.NewNotifier(conf),
 	}
 }
 
-// Wrapper around `NCClient` to time duration of requests to Nextcloud
-func (col *NCExporter) fetchNCServerInfo() (*models.NCServerInfo, error) {
-	timer := prometheus.NewTimer(metrics.ScrapeDuration)
-	defer timer.ObserveDuration()
-	return col.client.FetchNCServerInfo()
-}
-
 func (col *NCExporter) Collect(ch chan<- prometheus.Metric) {
+	log.Println("collecting metrics")
+	serverInfo, err := col.fetchNCServerInfo()
+
 	col.lock.Lock()
 	defer col.lock.Unlock()
-	log.Println("collecting metrics")
 
-	serverInfo, err := col.fetchNCServerInfo()
-	if err != nil {
-		metrics.NcUp.Set(0)
-		log.Println(err)
-	} else {
-		metrics.NcUp.Set(1)
-		col.mustCollectTaggedMetrics(serverInfo, ch)
+	if err == nil {
+		if err := col.collectTaggedMetrics(serverInfo, ch); err != nil {
+			metrics.ScrapeErrors.WithLabelValues(metrics.StageCollect).Inc()
+			log.Println(err)
+		}
+		col.collectInfoMetrics(serverInfo, ch)
+		if col.derive {
+			col.collectDerivedMetrics(serverInfo, ch)
+		}
 	}
 
 	metrics.ScrapeDuration.Collect(ch)
 	metrics.NcUp.Collect(ch)
 	metrics.ScrapeCount.Collect(ch)
+	metrics.LastSuccessfulScrape.Collect(ch)
+	metrics.ScrapeErrors.Collect(ch)
+	metrics.ScrapeErrorReason.Collect(ch)
+	metrics.BreakerState.Collect(ch)
+	metrics.DecodeWarnings.Collect(ch)
+	metrics.ChangeEvents.Collect(ch)
+	metrics.LastChange.Collect(ch)
+	if col.remoteWrite {
+		metrics.RemoteWriteSamplesSent.Collect(ch)
+		metrics.RemoteWriteSamplesDropped.Collect(ch)
+		metrics.RemoteWritePendingSamples.Collect(ch)
+	}
+	if col.strictDecode {
+		metrics.UnmappedFields.Collect(ch)
+	}
+	metrics.BuildInfo.Collect(ch)
 }
 
 func (col *NCExporter) Describe(ch chan<- *prometheus.Desc) {
+	// Relabelled metrics can't be described up front,
+	// so the exporter becomes an unchecked collector
+	if len(col.relabelRules) > 0 {
+		return
+	}
+
 	metrics.MetricsCollection.Describe(ch)
+	for _, metric := range metrics.SelfMetrics() {
+		metric.Describe(ch)
+	}
+}
 
-	metrics.ScrapeCount.Describe(ch)
-	metrics.ScrapeDuration.Describe(ch)
-	metrics.NcUp.Describe(ch)
+// Flag the reason for the last scrape failure, clearing all others.
+// An empty reason clears every flag
+func setScrapeErrorReason(reason string) {
+	for _, r := range client.ScrapeErrorReasons {
+		if r == reason {
+			metrics.ScrapeErrorReason.WithLabelValues(r).Set(1)
+		} else {
+			metrics.ScrapeErrorReason.WithLabelValues(r).Set(0)
+		}
+	}
 }
 
 func (col *NCExporter) shouldSkipMetric(name string, metricKind reflect.Kind) bool {
+	// String metrics are superseded by info metrics and only kept for backward compatibility
+	return col.isFiltered(name) || (metricKind == reflect.String && (col.excludeStrings || !col.legacyStrings))
+}
+
+// Check whether a metric is filtered out by name
+func (col *NCExporter) isFiltered(name string) bool {
+	fqName := prometheus.BuildFQName(metrics.Namespace, "", name)
 	return (strings.HasPrefix(name, "php") && col.excludePHP) || func() bool {
 		for _, filter := range col.filterMetrics {
-			if strings.Compare(filter, prometheus.BuildFQName(metrics.Namespace, "", name)) == 0 {
+			if strings.Compare(filter, fqName) == 0 {
 				return true
 			}
 		}
 		return false
-	}() || (metricKind == reflect.String && col.excludeStrings)
+	}() || !col.matchesFilters(fqName)
 }
 
-func (col *NCExporter) mustCollectTaggedMetrics(v interface{}, ch chan<- prometheus.Metric) error {
-	if err := col.collectTaggedMetrics(v, ch); err != nil {
-		panic(err)
+// Check a fully-qualified metric name against include and exclude regexes.
+// Without include regexes, every metric is included
+func (col *NCExporter) matchesFilters(fqName string) bool {
+	included := len(col.includeMetrics) == 0
+	for _, re := range col.includeMetrics {
+		if re.MatchString(fqName) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+
+	for _, re := range col.excludeMetrics {
+		if re.MatchString(fqName) {
+			return false
+		}
+	}
+	return true
+}
+
+// Send a metric built from a template, applying relabel rules if there are any
+func (col *NCExporter) emitMetric(ch chan<- prometheus.Metric, template metrics.MetricTemplate, value float64, labelValues ...string) {
+	col.emitMetricWithCreated(ch, template, value, time.Time{}, labelValues...)
+}
+
+// Send a metric built from a template with an optional created timestamp.
+// A zero created time is omitted
+func (col *NCExporter) emitMetricWithCreated(ch chan<- prometheus.Metric, template metrics.MetricTemplate, value float64, created time.Time, labelValues ...string) {
+	if len(col.relabelRules) > 0 {
+		if metric, ok := col.relabelMetric(template.FQName, template.Help, template.ValueType, template.VariableLabels, value, created, labelValues...); ok {
+			ch <- metric
+		}
+	} else if created.IsZero() {
+		ch <- template.MustEmitMetric(value, labelValues...)
 	} else {
-		return nil
+		ch <- template.MustEmitMetricWithCreated(value, created, labelValues...)
 	}
 }
 
@@ -98,6 +214,15 @@ func (col *NCExporter) collectTaggedMetrics(v interface{}, ch chan<- prometheus.
 		field := val.Field(fi)
 		fieldKind := field.Type().Kind()
 
+		// Optional values are left out when absent
+		if fieldKind == reflect.Ptr {
+			if field.IsNil() {
+				continue
+			}
+			field = field.Elem()
+			fieldKind = field.Kind()
+		}
+
 		if fieldKind == reflect.Struct {
 			// Recurse through nested structs
 			col.collectTaggedMetrics(field.Interface(), ch)
@@ -112,7 +237,7 @@ func (col *NCExporter) collectTaggedMetrics(v interface{}, ch chan<- prometheus.
 			if metricTemplate, ok := metrics.MetricsCollection.WithName(metricName); ok {
 				switch fieldKind {
 				case reflect.Float64:
-					ch <- metricTemplate.MustEmitMetric(field.Float(), labelValues...)
+					col.emitMetricWithCreated(ch, metricTemplate, field.Float(), createdTime(val, val.Type().Field(fi)), labelValues...)
 				case reflect.Bool:
 					var val float64
 					if field.Bool() {
@@ -120,10 +245,10 @@ func (col *NCExporter) collectTaggedMetrics(v interface{}, ch chan<- prometheus.
 					} else {
 						val = 0
 					}
-					ch <- metricTemplate.MustEmitMetric(val, labelValues...)
+					col.emitMetric(ch, metricTemplate, val, labelValues...)
 				case reflect.String:
 					labelValues := append(labelValues, field.String())
-					ch <- metricTemplate.MustEmitMetric(1, labelValues...)
+					col.emitMetric(ch, metricTemplate, 1, labelValues...)
 				default:
 					// TODO
 				}
@@ -135,3 +260,18 @@ func (col *NCExporter) collectTaggedMetrics(v interface{}, ch chan<- prometheus.
 
 	return nil
 }
+
+// Resolve the created timestamp of a counter field from the sibling field named by its tag.
+// Sibling fields hold Unix timestamps in seconds
+func createdTime(val reflect.Value, field reflect.StructField) time.Time {
+	name, ok := field.Tag.Lookup(metrics.CreatedTag)
+	if !ok {
+		return time.Time{}
+	}
+
+	created := val.FieldByName(name)
+	if !created.IsValid() || created.Kind() != reflect.Float64 || created.Float() <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(created.Float()), 0)
+}
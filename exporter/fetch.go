@@ -0,0 +1,110 @@
+package exporter
+
+import (
+	"log"
+	"time"
+
+	"github.com/MAKLs/nextcloud-exporter/client"
+	"github.com/MAKLs/nextcloud-exporter/metrics"
+	"github.com/MAKLs/nextcloud-exporter/models"
+)
+
+// Server info fetched successfully, along with when and how long it took
+type Snapshot struct {
+	Info      *models.NCServerInfo
+	FetchedAt time.Time
+	Latency   time.Duration
+}
+
+// Result of a request for server info, shared between scrapes
+type fetchResult struct {
+	info *models.NCServerInfo
+	err  error
+}
+
+// Fetch server info on behalf of a scrape.
+// Overlapping scrapes share one in-flight request, and results are reused
+// until the minimum interval between upstream requests has passed
+func (col *NCExporter) fetchNCServerInfo() (*models.NCServerInfo, error) {
+	result, _, _ := col.fetchGroup.Do("serverinfo", func() (interface{}, error) {
+		col.cacheLock.Lock()
+		if col.cached != nil && time.Since(col.fetchedAt) < col.minInterval {
+			cached := col.cached
+			col.cacheLock.Unlock()
+			return cached, nil
+		}
+		col.cacheLock.Unlock()
+
+		// The lock isn't held during the request, so status endpoints don't wait on a slow target.
+		// Requests are already serialised by the fetch group
+		start := time.Now()
+		info, err := col.client.FetchNCServerInfo()
+		duration := time.Since(start)
+		col.recordFetch(err)
+
+		col.cacheLock.Lock()
+		defer col.cacheLock.Unlock()
+
+		col.lastDuration = duration
+		if err == nil {
+			if col.lastSnapshot != nil {
+				col.detectChanges(col.lastSnapshot.Info, info)
+			}
+			col.lastSnapshot = &Snapshot{Info: info, FetchedAt: time.Now(), Latency: col.lastDuration}
+			if col.history != nil {
+				col.history.add(col.lastSnapshot)
+			}
+		} else {
+			col.lastError = err
+			col.lastErrorTime = time.Now()
+		}
+
+		col.cached = &fetchResult{info: info, err: err}
+		col.fetchedAt = time.Now()
+		return col.cached, nil
+	})
+
+	fetched := result.(*fetchResult)
+	return fetched.info, fetched.err
+}
+
+// Update exporter metrics from the outcome of an upstream request, notifying if the target went up or down
+func (col *NCExporter) recordFetch(err error) {
+	if col.notifier != nil {
+		col.notifier.Observe(col.client.Target(), err)
+	}
+
+	if err != nil {
+		metrics.NcUp.Set(0)
+		setScrapeErrorReason(client.ErrorReason(err))
+		log.Println(err)
+	} else {
+		metrics.NcUp.Set(1)
+		setScrapeErrorReason("")
+		metrics.LastSuccessfulScrape.SetToCurrentTime()
+	}
+}
+
+// Fields of the last successful response not mapped to any model.
+// Returns false unless strict decode mode is enabled
+func (col *NCExporter) UnmappedFields() ([]string, bool) {
+	if !col.strictDecode {
+		return nil, false
+	}
+
+	col.cacheLock.Lock()
+	defer col.cacheLock.Unlock()
+
+	if col.lastSnapshot == nil || col.lastSnapshot.Info.Unmapped == nil {
+		return []string{}, true
+	}
+	return col.lastSnapshot.Info.Unmapped, true
+}
+
+// Last server info fetched successfully, or nil if there is none
+func (col *NCExporter) LastSnapshot() *Snapshot {
+	col.cacheLock.Lock()
+	defer col.cacheLock.Unlock()
+
+	return col.lastSnapshot
+}
@@ -0,0 +1,166 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/MAKLs/nextcloud-exporter/models"
+)
+
+// Ring buffer of the last snapshots fetched, bounded by count and approximate memory use
+type history struct {
+	lock      sync.Mutex
+	size      int
+	maxBytes  int
+	bytes     int
+	snapshots []*Snapshot
+}
+
+func newHistory(size uint, maxBytes uint) *history {
+	if size == 0 {
+		return nil
+	}
+	return &history{size: int(size), maxBytes: int(maxBytes)}
+}
+
+// Memory held by a snapshot and its decoded server info, besides the raw response and unmapped paths.
+// The strings and app updates referenced by decoded info are small next to the struct itself
+const snapshotOverhead = int(unsafe.Sizeof(Snapshot{}) + unsafe.Sizeof(models.NCServerInfo{}))
+
+// Approximate memory held by a snapshot, dominated by its raw response
+func snapshotBytes(snapshot *Snapshot) int {
+	size := snapshotOverhead + len(snapshot.Info.Raw)
+	for _, path := range snapshot.Info.Unmapped {
+		size += int(unsafe.Sizeof(path)) + len(path)
+	}
+	return size
+}
+
+func (h *history) add(snapshot *Snapshot) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.snapshots = append(h.snapshots, snapshot)
+	h.bytes += snapshotBytes(snapshot)
+
+	// Evict the oldest snapshots, always keeping the newest
+	for len(h.snapshots) > 1 && (len(h.snapshots) > h.size || (h.maxBytes > 0 && h.bytes > h.maxBytes)) {
+		h.bytes -= snapshotBytes(h.snapshots[0])
+		h.snapshots[0] = nil
+		h.snapshots = h.snapshots[1:]
+	}
+}
+
+func (h *history) list() []*Snapshot {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return append([]*Snapshot(nil), h.snapshots...)
+}
+
+// Change to a single field between consecutive snapshots
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+type HistoryEntry struct {
+	FetchedAt      time.Time `json:"fetched_at"`
+	LatencySeconds float64   `json:"latency_seconds"`
+	// Changes since the previous snapshot, empty for the oldest one
+	Changes []FieldChange `json:"changes"`
+}
+
+// Snapshots in the history, oldest first, each with the fields that changed since the one before.
+// Returns false if the history is disabled
+func (col *NCExporter) History() ([]HistoryEntry, bool) {
+	if col.history == nil {
+		return nil, false
+	}
+
+	snapshots := col.history.list()
+	entries := make([]HistoryEntry, 0, len(snapshots))
+	var previous map[string]interface{}
+	for i, snapshot := range snapshots {
+		fields, err := flattenFields(snapshot.Info)
+		if err != nil {
+			fields = map[string]interface{}{}
+		}
+
+		entry := HistoryEntry{
+			FetchedAt:      snapshot.FetchedAt,
+			LatencySeconds: snapshot.Latency.Seconds(),
+			Changes:        []FieldChange{},
+		}
+		if i > 0 {
+			entry.Changes = diffFields(previous, fields)
+		}
+		entries = append(entries, entry)
+		previous = fields
+	}
+	return entries, true
+}
+
+// Flatten a value's JSON representation into its leaf values by path
+func flattenFields(v interface{}) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var raw interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]interface{})
+	flatten(raw, "", fields)
+	return fields, nil
+}
+
+func flatten(v interface{}, path string, fields map[string]interface{}) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for key, child := range val {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			flatten(child, childPath, fields)
+		}
+	case []interface{}:
+		for i, child := range val {
+			flatten(child, fmt.Sprintf("%s[%d]", path, i), fields)
+		}
+	default:
+		fields[path] = v
+	}
+}
+
+func diffFields(before map[string]interface{}, after map[string]interface{}) []FieldChange {
+	changes := make([]FieldChange, 0)
+	for field, newValue := range after {
+		if oldValue, ok := before[field]; !ok || !reflect.DeepEqual(oldValue, newValue) {
+			changes = append(changes, FieldChange{Field: field, Old: oldValue, New: newValue})
+		}
+	}
+	for field, oldValue := range before {
+		if _, ok := after[field]; !ok {
+			changes = append(changes, FieldChange{Field: field, Old: oldValue, New: nil})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
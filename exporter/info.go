@@ -0,0 +1,65 @@
+package exporter
+
+import (
+	"log"
+	"reflect"
+
+	"github.com/MAKLs/nextcloud-exporter/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Gather label values for info metrics from tagged string fields.
+// Info metrics consolidate several fields into the labels of a single series
+func gatherInfoLabels(val reflect.Value, infos map[string]map[string]string) {
+	if val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return
+	}
+
+	for fi := 0; fi < val.NumField(); fi++ {
+		field := val.Field(fi)
+		tag := val.Type().Field(fi).Tag
+
+		if field.Kind() == reflect.Struct {
+			// Recurse through nested structs
+			gatherInfoLabels(field, infos)
+		} else if infoName, ok := tag.Lookup(metrics.InfoTag); ok && field.Kind() == reflect.String {
+			label, ok := tag.Lookup(metrics.InfoLabelTag)
+			if !ok {
+				log.Printf("%s tagged for info metric %s without a label", val.Type().Field(fi).Name, infoName)
+				continue
+			}
+
+			if _, ok := infos[infoName]; !ok {
+				infos[infoName] = make(map[string]string)
+			}
+			infos[infoName][label] = field.String()
+		}
+	}
+}
+
+func (col *NCExporter) collectInfoMetrics(v interface{}, ch chan<- prometheus.Metric) {
+	infos := make(map[string]map[string]string)
+	gatherInfoLabels(reflect.ValueOf(v), infos)
+
+	for infoName, labels := range infos {
+		if col.isFiltered(infoName) {
+			continue
+		}
+
+		metricTemplate, ok := metrics.MetricsCollection.WithName(infoName)
+		if !ok {
+			log.Printf("%s tagged for export but no corresponding metric template found", infoName)
+			continue
+		}
+
+		// Order label values as declared by the template
+		labelValues := make([]string, 0, len(metricTemplate.VariableLabels))
+		for _, label := range metricTemplate.VariableLabels {
+			labelValues = append(labelValues, labels[label])
+		}
+		col.emitMetric(ch, metricTemplate, 1, labelValues...)
+	}
+}
@@ -0,0 +1,209 @@
+package exporter
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MAKLs/nextcloud-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	metricNameLabel    = "__name__"
+	defaultSeparator   = ";"
+	defaultRegex       = "(.*)"
+	defaultReplacement = "$1"
+)
+
+const (
+	relabelReplace   = "replace"
+	relabelKeep      = "keep"
+	relabelDrop      = "drop"
+	relabelLabelDrop = "labeldrop"
+	relabelLabelKeep = "labelkeep"
+)
+
+type relabelRule struct {
+	sourceLabels []string
+	separator    string
+	regex        *regexp.Regexp
+	targetLabel  string
+	replacement  string
+	action       string
+}
+
+// Compile a regex anchored at both ends, as Prometheus does
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+func mustCompileAnchored(exprs []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := compileAnchored(expr)
+		if err != nil {
+			panic(fmt.Sprintf("failed to compile metric filter '%s': %v", expr, err))
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled
+}
+
+func mustCompileRelabelRules(configs []config.RelabelConfig) []relabelRule {
+	rules := make([]relabelRule, 0, len(configs))
+	for _, conf := range configs {
+		rule := relabelRule{
+			sourceLabels: conf.SourceLabels,
+			separator:    conf.Separator,
+			targetLabel:  conf.TargetLabel,
+			replacement:  conf.Replacement,
+			action:       strings.ToLower(conf.Action),
+		}
+
+		if rule.separator == "" {
+			rule.separator = defaultSeparator
+		}
+		if rule.replacement == "" {
+			rule.replacement = defaultReplacement
+		}
+		if rule.action == "" {
+			rule.action = relabelReplace
+		}
+
+		expr := conf.Regex
+		if expr == "" {
+			expr = defaultRegex
+		}
+		re, err := compileAnchored(expr)
+		if err != nil {
+			panic(fmt.Sprintf("failed to compile relabel regex '%s': %v", expr, err))
+		}
+		rule.regex = re
+
+		switch rule.action {
+		case relabelReplace:
+			if rule.targetLabel == "" {
+				panic(fmt.Sprintf("relabel action '%s' requires a target_label", rule.action))
+			}
+			// Names outside the legacy charset need escaping by scrapers, so they're rejected
+			if !model.LabelName(rule.targetLabel).IsValidLegacy() {
+				panic(fmt.Sprintf("invalid relabel target_label '%s'", rule.targetLabel))
+			}
+		case relabelKeep, relabelDrop, relabelLabelDrop, relabelLabelKeep:
+		default:
+			panic(fmt.Sprintf("unknown relabel action '%s'", rule.action))
+		}
+
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// Apply relabel rules to a set of labels, including `__name__`.
+// Returns false if the metric should be dropped
+func relabel(labels map[string]string, rules []relabelRule) bool {
+	for _, rule := range rules {
+		values := make([]string, 0, len(rule.sourceLabels))
+		for _, name := range rule.sourceLabels {
+			values = append(values, labels[name])
+		}
+		value := strings.Join(values, rule.separator)
+
+		switch rule.action {
+		case relabelReplace:
+			match := rule.regex.FindStringSubmatchIndex(value)
+			if match == nil {
+				break
+			}
+			target := string(rule.regex.ExpandString([]byte{}, rule.replacement, value, match))
+			if target == "" {
+				delete(labels, rule.targetLabel)
+			} else {
+				labels[rule.targetLabel] = target
+			}
+		case relabelKeep:
+			if !rule.regex.MatchString(value) {
+				return false
+			}
+		case relabelDrop:
+			if rule.regex.MatchString(value) {
+				return false
+			}
+		case relabelLabelDrop:
+			for name := range labels {
+				if name != metricNameLabel && rule.regex.MatchString(name) {
+					delete(labels, name)
+				}
+			}
+		case relabelLabelKeep:
+			for name := range labels {
+				if name != metricNameLabel && !rule.regex.MatchString(name) {
+					delete(labels, name)
+				}
+			}
+		}
+	}
+
+	// A metric can't be exported without a name
+	return labels[metricNameLabel] != ""
+}
+
+// Build a metric from a template after applying relabel rules.
+// Descriptors are cached, since relabelled metrics share them across scrapes
+func (col *NCExporter) relabelMetric(fqName string, help string, valueType prometheus.ValueType, labelNames []string, value float64, created time.Time, labelValues ...string) (prometheus.Metric, bool) {
+	labels := make(map[string]string, len(labelNames)+1)
+	labels[metricNameLabel] = fqName
+	for i, name := range labelNames {
+		labels[name] = labelValues[i]
+	}
+
+	if !relabel(labels, col.relabelRules) {
+		return nil, false
+	}
+
+	name := labels[metricNameLabel]
+	delete(labels, metricNameLabel)
+	if !model.IsValidLegacyMetricName(name) {
+		// Replacements can produce a name that isn't valid
+		log.Printf("failed to relabel %s: invalid metric name '%s'", fqName, name)
+		return nil, false
+	}
+
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+
+	values := make([]string, 0, len(names))
+	for _, label := range names {
+		values = append(values, labels[label])
+	}
+
+	key := name + "\xff" + strings.Join(names, "\xff")
+	desc, ok := col.relabelledDescs[key]
+	if !ok {
+		desc = prometheus.NewDesc(name, help, names, nil)
+		col.relabelledDescs[key] = desc
+	}
+
+	var (
+		metric prometheus.Metric
+		err    error
+	)
+	if created.IsZero() {
+		metric, err = prometheus.NewConstMetric(desc, valueType, value, values...)
+	} else {
+		metric, err = prometheus.NewConstMetricWithCreatedTimestamp(desc, valueType, value, created, values...)
+	}
+	if err != nil {
+		log.Printf("failed to relabel %s: %v", fqName, err)
+		return nil, false
+	}
+	return metric, true
+}
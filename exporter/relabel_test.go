@@ -0,0 +1,218 @@
+package exporter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MAKLs/nextcloud-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestRelabel(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  []config.RelabelConfig
+		labels map[string]string
+		want   map[string]string
+		keep   bool
+	}{
+		{
+			name:   "replace with defaults copies the source",
+			rules:  []config.RelabelConfig{{SourceLabels: []string{"cache_type"}, TargetLabel: "cache"}},
+			labels: map[string]string{"__name__": "nextcloud_memcache_type", "cache_type": "redis"},
+			want:   map[string]string{"__name__": "nextcloud_memcache_type", "cache_type": "redis", "cache": "redis"},
+			keep:   true,
+		},
+		{
+			name:   "replace joins source labels with the separator",
+			rules:  []config.RelabelConfig{{SourceLabels: []string{"a", "b"}, Separator: "-", Regex: "(.*)-(.*)", Replacement: "$2/$1", TargetLabel: "ab"}},
+			labels: map[string]string{"__name__": "m", "a": "x", "b": "y"},
+			want:   map[string]string{"__name__": "m", "a": "x", "b": "y", "ab": "y/x"},
+			keep:   true,
+		},
+		{
+			name:   "replace without a match leaves labels unchanged",
+			rules:  []config.RelabelConfig{{SourceLabels: []string{"a"}, Regex: "z", Replacement: "matched", TargetLabel: "b"}},
+			labels: map[string]string{"__name__": "m", "a": "xyz"},
+			want:   map[string]string{"__name__": "m", "a": "xyz"},
+			keep:   true,
+		},
+		{
+			name:   "regexes are anchored",
+			rules:  []config.RelabelConfig{{SourceLabels: []string{"a"}, Regex: "x", Replacement: "matched", TargetLabel: "b"}},
+			labels: map[string]string{"__name__": "m", "a": "xx"},
+			want:   map[string]string{"__name__": "m", "a": "xx"},
+			keep:   true,
+		},
+		{
+			name:   "empty replacement deletes the label",
+			rules:  []config.RelabelConfig{{SourceLabels: []string{"a"}, Regex: "x", Replacement: "$0", TargetLabel: "b"}, {SourceLabels: []string{"missing"}, Regex: "", TargetLabel: "a"}},
+			labels: map[string]string{"__name__": "m", "a": "x", "b": "old"},
+			want:   map[string]string{"__name__": "m", "b": "x"},
+			keep:   true,
+		},
+		{
+			name:   "rename through __name__",
+			rules:  []config.RelabelConfig{{SourceLabels: []string{"__name__"}, Regex: "nextcloud_(.*)", Replacement: "nc_$1", TargetLabel: "__name__"}},
+			labels: map[string]string{"__name__": "nextcloud_active_users_total"},
+			want:   map[string]string{"__name__": "nc_active_users_total"},
+			keep:   true,
+		},
+		{
+			name:   "keep matching",
+			rules:  []config.RelabelConfig{{SourceLabels: []string{"__name__"}, Regex: "nextcloud_php_.*", Action: "keep"}},
+			labels: map[string]string{"__name__": "nextcloud_php_info"},
+			want:   map[string]string{"__name__": "nextcloud_php_info"},
+			keep:   true,
+		},
+		{
+			name:   "keep drops others",
+			rules:  []config.RelabelConfig{{SourceLabels: []string{"__name__"}, Regex: "nextcloud_php_.*", Action: "keep"}},
+			labels: map[string]string{"__name__": "nextcloud_system_info"},
+			keep:   false,
+		},
+		{
+			name:   "drop matching, with the action case-insensitive",
+			rules:  []config.RelabelConfig{{SourceLabels: []string{"storage_location"}, Regex: "local", Action: "DROP"}},
+			labels: map[string]string{"__name__": "nextcloud_storages", "storage_location": "local"},
+			keep:   false,
+		},
+		{
+			name:   "labeldrop keeps the name",
+			rules:  []config.RelabelConfig{{Regex: "cache_.*|__name__", Action: "labeldrop"}},
+			labels: map[string]string{"__name__": "m", "cache_type": "redis", "cache_location": "local", "other": "x"},
+			want:   map[string]string{"__name__": "m", "other": "x"},
+			keep:   true,
+		},
+		{
+			name:   "labelkeep keeps the name",
+			rules:  []config.RelabelConfig{{Regex: "cache_type", Action: "labelkeep"}},
+			labels: map[string]string{"__name__": "m", "cache_type": "redis", "cache_location": "local"},
+			want:   map[string]string{"__name__": "m", "cache_type": "redis"},
+			keep:   true,
+		},
+		{
+			name:   "rules apply in order",
+			rules:  []config.RelabelConfig{{SourceLabels: []string{"a"}, TargetLabel: "b"}, {SourceLabels: []string{"b"}, Regex: "x", Action: "drop"}},
+			labels: map[string]string{"__name__": "m", "a": "x"},
+			keep:   false,
+		},
+		{
+			name:   "removing the name drops the metric",
+			rules:  []config.RelabelConfig{{SourceLabels: []string{"missing"}, TargetLabel: "__name__"}},
+			labels: map[string]string{"__name__": "m"},
+			keep:   false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keep := relabel(test.labels, mustCompileRelabelRules(test.rules))
+			if keep != test.keep {
+				t.Fatalf("keep = %v, want %v", keep, test.keep)
+			}
+			if keep && !reflect.DeepEqual(test.labels, test.want) {
+				t.Errorf("labels = %v, want %v", test.labels, test.want)
+			}
+		})
+	}
+}
+
+func TestMustCompileRelabelRulesInvalid(t *testing.T) {
+	tests := map[string]config.RelabelConfig{
+		"unknown action":        {Action: "hashmod"},
+		"missing target label":  {SourceLabels: []string{"a"}},
+		"invalid target label":  {SourceLabels: []string{"a"}, TargetLabel: "foo-bar"},
+		"invalid regex":         {Regex: "(", Action: "drop"},
+		"invalid regex replace": {Regex: "[", TargetLabel: "a"},
+	}
+
+	for name, rule := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected %+v to be rejected", rule)
+				}
+			}()
+			mustCompileRelabelRules([]config.RelabelConfig{rule})
+		})
+	}
+}
+
+func TestRelabelMetric(t *testing.T) {
+	col := &NCExporter{
+		relabelRules: mustCompileRelabelRules([]config.RelabelConfig{
+			{SourceLabels: []string{"__name__"}, Regex: "nextcloud_(.*)", Replacement: "nc_$1", TargetLabel: "__name__"},
+			{SourceLabels: []string{"__name__"}, Regex: "nc_invalid", Replacement: "1nvalid-name", TargetLabel: "__name__"},
+		}),
+		relabelledDescs: make(map[string]*prometheus.Desc),
+	}
+
+	first, ok := col.relabelMetric("nextcloud_memcache_type", "Type of cache.", prometheus.GaugeValue, []string{"cache_type", "cache_location"}, 1, time.Time{}, "redis", "local")
+	if !ok {
+		t.Fatal("relabelled metric was dropped")
+	}
+	var m dto.Metric
+	if err := first.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{}
+	for _, pair := range m.GetLabel() {
+		labels[pair.GetName()] = pair.GetValue()
+	}
+	if want := map[string]string{"cache_type": "redis", "cache_location": "local"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("labels = %v, want %v", labels, want)
+	}
+
+	// Metrics with the same name and labels share a descriptor
+	second, ok := col.relabelMetric("nextcloud_memcache_type", "Type of cache.", prometheus.GaugeValue, []string{"cache_type", "cache_location"}, 1, time.Time{}, "apcu", "local")
+	if !ok {
+		t.Fatal("relabelled metric was dropped")
+	}
+	if first.Desc() != second.Desc() {
+		t.Errorf("descriptors aren't cached: %s, %s", first.Desc(), second.Desc())
+	}
+	if want := `Desc{fqName: "nc_memcache_type", help: "Type of cache.", constLabels: {}, variableLabels: {cache_location,cache_type}}`; first.Desc().String() != want {
+		t.Errorf("desc = %s, want %s", first.Desc(), want)
+	}
+	if len(col.relabelledDescs) != 1 {
+		t.Errorf("cached %d descriptors, want 1", len(col.relabelledDescs))
+	}
+
+	// A replacement producing an invalid name drops the metric
+	if metric, ok := col.relabelMetric("nextcloud_invalid", "Invalid.", prometheus.GaugeValue, nil, 1, time.Time{}); ok {
+		t.Errorf("metric with an invalid name was kept: %s", metric.Desc())
+	}
+}
+
+func TestMatchesFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		fqName  string
+		want    bool
+	}{
+		{"no filters", nil, nil, "nextcloud_php_info", true},
+		{"included", []string{"nextcloud_php_.*"}, nil, "nextcloud_php_info", true},
+		{"not included", []string{"nextcloud_php_.*"}, nil, "nextcloud_system_info", false},
+		{"any include matches", []string{"nextcloud_php_.*", "nextcloud_system_.*"}, nil, "nextcloud_system_info", true},
+		{"include is anchored at the start", []string{"php_info"}, nil, "nextcloud_php_info", false},
+		{"include is anchored at the end", []string{"nextcloud_php"}, nil, "nextcloud_php_info", false},
+		{"excluded", nil, []string{"nextcloud_php_.*"}, "nextcloud_php_info", false},
+		{"exclude is anchored", nil, []string{"php"}, "nextcloud_php_info", true},
+		{"exclude wins over include", []string{"nextcloud_.*"}, []string{"nextcloud_php_opcache_.*"}, "nextcloud_php_opcache_hits_total", false},
+		{"alternation is anchored as a whole", []string{"nextcloud_php_info|nextcloud_system"}, nil, "nextcloud_system_info", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			col := &NCExporter{includeMetrics: mustCompileAnchored(test.include), excludeMetrics: mustCompileAnchored(test.exclude)}
+			if got := col.matchesFilters(test.fqName); got != test.want {
+				t.Errorf("matchesFilters(%s) = %v, want %v", test.fqName, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package exporter
+
+import (
+	"context"
+	"time"
+)
+
+// State of the exporter's target as reported by readiness checks
+type TargetStatus struct {
+	Target        string     `json:"target"`
+	Up            bool       `json:"up"`
+	LastSuccess   *time.Time `json:"last_success"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+	CircuitState  string     `json:"circuit_state"`
+	// Duration of the last request for server info, including retries
+	LastDurationSeconds float64 `json:"last_duration_seconds"`
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Host the exporter fetches server info from
+func (col *NCExporter) Target() string {
+	return col.client.Target()
+}
+
+func (col *NCExporter) Status() TargetStatus {
+	col.cacheLock.Lock()
+	defer col.cacheLock.Unlock()
+
+	lastSuccess := col.lastSuccess()
+	status := TargetStatus{
+		Target:              col.client.Target(),
+		Up:                  !lastSuccess.IsZero() && !lastSuccess.Before(col.lastErrorTime),
+		LastSuccess:         optionalTime(lastSuccess),
+		LastErrorTime:       optionalTime(col.lastErrorTime),
+		CircuitState:        col.client.BreakerState(),
+		LastDurationSeconds: col.lastDuration.Seconds(),
+	}
+	if col.lastError != nil {
+		status.LastError = col.lastError.Error()
+	}
+	return status
+}
+
+// Check whether server info was fetched successfully within the ready window.
+// Only the outcome of past fetches is reported, so probes never wait on the target
+func (col *NCExporter) Ready() (bool, TargetStatus) {
+	return col.succeededWithin(col.readyWindow), col.Status()
+}
+
+func (col *NCExporter) succeededWithin(window time.Duration) bool {
+	col.cacheLock.Lock()
+	defer col.cacheLock.Unlock()
+
+	lastSuccess := col.lastSuccess()
+	return !lastSuccess.IsZero() && time.Since(lastSuccess) <= window
+}
+
+// Fetch server info in the background, so readiness doesn't depend on scrapes.
+// Server info is fetched once on start, then again whenever scrapes
+// haven't refreshed it within half the ready window
+func (col *NCExporter) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	col.cancel = cancel
+
+	go func() {
+		col.fetchNCServerInfo()
+
+		interval := col.readyWindow / 2
+		if interval <= 0 {
+			return
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if !col.succeededWithin(interval) {
+				col.fetchNCServerInfo()
+			}
+		}
+	}()
+}
+
+// Stop fetching in the background. A fetch in flight isn't interrupted
+func (col *NCExporter) Stop() {
+	col.cancel()
+}
+
+// Must be called with the cache lock held
+func (col *NCExporter) lastSuccess() time.Time {
+	if col.lastSnapshot == nil {
+		return time.Time{}
+	}
+	return col.lastSnapshot.FetchedAt
+}
@@ -0,0 +1,101 @@
+// Stand-in HTTP server for tests of requests the exporter sends in the background
+package testserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Long enough for a request sent in the background to arrive
+const Timeout = 2 * time.Second
+
+type Request struct {
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// Server recording the requests it receives.
+// It answers with the given status codes in turn, and with 200 after them
+type Receiver struct {
+	URL      url.URL
+	t        *testing.T
+	lock     sync.Mutex
+	statuses []int
+	requests chan Request
+}
+
+func NewReceiver(t *testing.T, statuses ...int) *Receiver {
+	t.Helper()
+
+	r := &Receiver{t: t, statuses: statuses, requests: make(chan Request, 100)}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	serverUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.URL = *serverUrl
+	return r
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		r.t.Errorf("failed to read request body: %v", err)
+	}
+
+	r.lock.Lock()
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status = r.statuses[0]
+		r.statuses = r.statuses[1:]
+	}
+	r.lock.Unlock()
+
+	r.requests <- Request{Path: req.URL.Path, Header: req.Header, Body: body}
+	w.WriteHeader(status)
+}
+
+// Wait for the next request
+func (r *Receiver) Receive() Request {
+	r.t.Helper()
+
+	select {
+	case req := <-r.requests:
+		return req
+	case <-time.After(Timeout):
+		r.t.Fatal("no request received")
+		return Request{}
+	}
+}
+
+// Fail if a request arrives shortly
+func (r *Receiver) ExpectNone() {
+	r.t.Helper()
+
+	select {
+	case req := <-r.requests:
+		r.t.Fatalf("unexpected request to %s: %s", req.Path, req.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Wait until the condition holds, failing after the timeout
+func WaitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(Timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
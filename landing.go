@@ -0,0 +1,97 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+
+	"github.com/MAKLs/nextcloud-exporter/config"
+	"github.com/MAKLs/nextcloud-exporter/exporter"
+	"github.com/MAKLs/nextcloud-exporter/version"
+)
+
+// Directory of templates for HTML pages, relative to the working directory
+const templatesDir = "templates"
+
+type landingLink struct {
+	Name string
+	Path string
+}
+
+// Filters shown on the landing page.
+// Copied from the config, so templates can't render credentials
+type landingFilters struct {
+	ExcludePHP          bool
+	ExcludeStrings      bool
+	LegacyStringMetrics bool
+	DerivedMetrics      bool
+	FilterMetrics       []string
+	IncludeMetrics      []string
+	ExcludeMetrics      []string
+	RelabelRules        int
+}
+
+type landingPage struct {
+	Version  string
+	Revision string
+	Targets  []exporter.TargetStatus
+	Filters  landingFilters
+	Links    []landingLink
+}
+
+// Render the landing page at `/`.
+// Templates are parsed on every start, so edits apply on reload
+func landing(appConfig *config.Config, ncExporter *exporter.NCExporter) (http.Handler, error) {
+	tmpl, err := template.ParseGlob(filepath.Join(templatesDir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+
+	links := []landingLink{
+		{Name: "Metrics", Path: appConfig.MetricsPath},
+		{Name: "Readiness", Path: config.ReadyPath},
+		{Name: "Health", Path: config.HealthyPath},
+	}
+	if len(appConfig.BasicAuthUsers) > 0 {
+		links = append(links, landingLink{Name: "Server info", Path: config.ServerInfoPath})
+		if appConfig.HistorySize > 0 {
+			links = append(links, landingLink{Name: "History", Path: config.HistoryPath})
+		}
+		if appConfig.StrictDecode {
+			links = append(links, landingLink{Name: "Unmapped fields", Path: config.UnmappedPath})
+		}
+	}
+
+	filters := landingFilters{
+		ExcludePHP:          appConfig.ExcludePHP,
+		ExcludeStrings:      appConfig.ExcludeStrings,
+		LegacyStringMetrics: appConfig.LegacyStringMetrics,
+		DerivedMetrics:      appConfig.DerivedMetrics,
+		FilterMetrics:       appConfig.FilterMetrics,
+		IncludeMetrics:      appConfig.IncludeMetrics,
+		ExcludeMetrics:      appConfig.ExcludeMetrics,
+		RelabelRules:        len(appConfig.RelabelRules),
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every unmatched path falls through to `/`
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		page := landingPage{
+			Version:  version.Version,
+			Revision: version.Revision,
+			Targets:  []exporter.TargetStatus{ncExporter.Status()},
+			Filters:  filters,
+			Links:    links,
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.ExecuteTemplate(w, "index.html", page); err != nil {
+			log.Printf("failed to render landing page: %v", err)
+		}
+	}), nil
+}
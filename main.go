@@ -3,11 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -15,8 +16,13 @@ import (
 	"github.com/MAKLs/nextcloud-exporter/config"
 	"github.com/MAKLs/nextcloud-exporter/exporter"
 	"github.com/MAKLs/nextcloud-exporter/metrics"
+	"github.com/MAKLs/nextcloud-exporter/pusher"
+	"github.com/MAKLs/nextcloud-exporter/remotewrite"
+	"github.com/MAKLs/nextcloud-exporter/web"
 	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 )
 
 const (
@@ -24,11 +30,33 @@ const (
 )
 
 var (
-	ncRegistry = metrics.ExporterRegistry
-	ncExporter *exporter.NCExporter
-	mux        *http.ServeMux
+	// Background work of the running exporter, only touched by start and stop.
+	// Handlers are given the exporter when the mux is built
+	ncExporter     *exporter.NCExporter
+	ncPusher       *pusher.Pusher
+	ncRemoteWriter *remotewrite.Sender
+	// Registry for the current exporter, rebuilt on every start so
+	// config changes (e.g. const labels) apply to its descriptors
+	ncRegistry     *prometheus.Registry
+	ncRegistryLock sync.RWMutex
 )
 
+func setExporterRegistry(registry *prometheus.Registry) {
+	ncRegistryLock.Lock()
+	defer ncRegistryLock.Unlock()
+	ncRegistry = registry
+}
+
+func gatherMetrics() ([]*dto.MetricFamily, error) {
+	ncRegistryLock.RLock()
+	defer ncRegistryLock.RUnlock()
+
+	if ncRegistry == nil {
+		return nil, nil
+	}
+	return ncRegistry.Gather()
+}
+
 func healthz() http.Handler {
 	health := struct {
 		Status string `json:"status"`
@@ -46,7 +74,112 @@ func healthz() http.Handler {
 	})
 }
 
-func stop(serverChan <-chan *http.Server, errorChan chan<- error) {
+// Ready once the latest config was loaded and the target was reached within the ready window
+func ready(ncExporter *exporter.NCExporter) http.Handler {
+	type readiness struct {
+		Status      string                  `json:"status"`
+		ConfigError string                  `json:"config_error,omitempty"`
+		Targets     []exporter.TargetStatus `json:"targets"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		isReady, target := ncExporter.Ready()
+		status := readiness{Status: "NOT_READY", Targets: []exporter.TargetStatus{target}}
+		code := http.StatusServiceUnavailable
+
+		// A config that failed to reload leaves the exporter running on a stale one
+		if err := config.ReloadError(); err != nil {
+			status.ConfigError = err.Error()
+		} else if isReady {
+			status.Status = "READY"
+			code = http.StatusOK
+		}
+
+		body, err := json.Marshal(status)
+		if err != nil {
+			log.Fatalf("failed to serialize readiness status: %s", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write(body)
+	})
+}
+
+func history(ncExporter *exporter.NCExporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entries, enabled := ncExporter.History()
+		if !enabled {
+			http.Error(w, "history is disabled", http.StatusNotFound)
+			return
+		}
+
+		body, err := json.Marshal(struct {
+			Snapshots []exporter.HistoryEntry `json:"snapshots"`
+		}{Snapshots: entries})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	})
+}
+
+func unmappedFields(ncExporter *exporter.NCExporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		unmapped, enabled := ncExporter.UnmappedFields()
+		if !enabled {
+			http.Error(w, "strict decode mode is disabled", http.StatusNotFound)
+			return
+		}
+
+		body, err := json.Marshal(struct {
+			UnmappedFields []string `json:"unmapped_fields"`
+		}{UnmappedFields: unmapped})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	})
+}
+
+// Prepare endpoints
+func newMux(appConfig *config.Config, ncExporter *exporter.NCExporter) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle(config.HealthzPath, healthz())
+	mux.Handle(config.HealthyPath, healthz())
+	mux.Handle(config.ReadyPath, ready(ncExporter))
+	// Server info, and the debug views derived from it, are only served to authenticated clients
+	if len(appConfig.BasicAuthUsers) > 0 {
+		mux.Handle(config.ServerInfoPath, serverInfo(ncExporter))
+		mux.Handle(config.UnmappedPath, unmappedFields(ncExporter))
+		mux.Handle(config.HistoryPath, history(ncExporter))
+	}
+	// Metrics served at the root replace the landing page
+	if appConfig.MetricsPath != "/" {
+		if handler, err := landing(appConfig, ncExporter); err != nil {
+			log.Printf("landing page disabled: %v", err)
+		} else {
+			mux.Handle("/", handler)
+		}
+	}
+	mux.Handle(appConfig.MetricsPath, promhttp.HandlerFor(prometheus.GathererFunc(gatherMetrics), promhttp.HandlerOpts{
+		EnableOpenMetrics:                   true,
+		EnableOpenMetricsTextCreatedSamples: true,
+	}))
+	return mux
+}
+
+// Stop the server and background work.
+// On shutdown, state kept outside the exporter is cleaned up as well
+func stop(serverChan <-chan *http.Server, errorChan chan<- error, shutdown bool) {
 	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer func() {
 		cancel()
@@ -55,22 +188,79 @@ func stop(serverChan <-chan *http.Server, errorChan chan<- error) {
 	server := <-serverChan
 	log.Println("stopping server")
 	errorChan <- server.Shutdown(ctx)
-	ncRegistry.Unregister(ncExporter)
+	if ncExporter != nil {
+		ncExporter.Stop()
+		ncExporter = nil
+	}
+	if ncPusher != nil {
+		ncPusher.Stop()
+		if shutdown {
+			ncPusher.Delete(ctx)
+		}
+		ncPusher = nil
+	}
+	if ncRemoteWriter != nil {
+		ncRemoteWriter.Stop()
+		ncRemoteWriter = nil
+	}
+	setExporterRegistry(nil)
 }
 
 func start(serverChan chan<- *http.Server, errorChan chan<- error) {
 	appConfig := config.GetConfig()
-	ncClient := client.NewNCClient(&appConfig.Url, appConfig.Token)
-	ncExporter = exporter.NewNCExporter(ncClient, appConfig.ExcludePHP, appConfig.ExcludeStrings, appConfig.FilterMetrics)
-	ncRegistry.MustRegister(ncExporter)
-	server := &http.Server{Handler: mux, Addr: fmt.Sprintf(":%d", appConfig.Port)}
+	ncClient := client.NewNCClient(appConfig)
+	ncExporter = exporter.NewNCExporter(ncClient, appConfig)
+	registry := prometheus.NewRegistry()
+	prometheus.WrapRegistererWith(prometheus.Labels(appConfig.Labels), registry).MustRegister(append(metrics.RuntimeCollectors(), ncExporter)...)
+	setExporterRegistry(registry)
+	ncExporter.Start()
+	// Set before the server is handed over, so stop sees it
+	ncPusher = pusher.NewPusher(appConfig, prometheus.GathererFunc(gatherMetrics), ncClient.Target())
+	if ncPusher != nil {
+		ncPusher.Start()
+	}
+	ncRemoteWriter = remotewrite.NewSender(appConfig, prometheus.GathererFunc(gatherMetrics))
+	if ncRemoteWriter != nil {
+		ncRemoteWriter.Start()
+	}
+	server := &http.Server{Handler: web.MustBasicAuth(appConfig.BasicAuthUsers, newMux(appConfig, ncExporter))}
+	if web.TLSEnabled(&appConfig.TLSServerConfig) {
+		server.TLSConfig = web.MustTLSConfig(&appConfig.TLSServerConfig)
+	}
 	serverChan <- server
-	log.Printf("starting server at :%d", appConfig.Port)
-	errorChan <- server.ListenAndServe()
+
+	listeners, err := web.ListenAll(appConfig.Listen())
+	if err != nil {
+		errorChan <- err
+		return
+	}
+
+	// Serving configures HTTP/2 on the server, which sets a TLS config
+	useTLS := server.TLSConfig != nil
+
+	// Report a single error once any listener stops, stopping the others with it
+	serveErrors := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			if useTLS {
+				log.Printf("starting TLS server at %s", listener.Addr())
+				serveErrors <- server.ServeTLS(listener, "", "")
+			} else {
+				log.Printf("starting server at %s", listener.Addr())
+				serveErrors <- server.Serve(listener)
+			}
+		}(listener)
+	}
+
+	err = <-serveErrors
+	if err != http.ErrServerClosed {
+		server.Close()
+	}
+	errorChan <- err
 }
 
 func restart(serverChan chan *http.Server, errorChan chan error) {
-	stop(serverChan, errorChan)
+	stop(serverChan, errorChan, false)
 	start(serverChan, errorChan)
 }
 
@@ -86,11 +276,6 @@ func main() {
 	config.Notify(reloadChan)
 	signal.Notify(shutdownChan, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
-	// Prepare endpoints
-	mux = http.NewServeMux()
-	mux.Handle("/healthz", healthz())
-	mux.Handle("/metrics", promhttp.HandlerFor(metrics.ExporterRegistry, promhttp.HandlerOpts{}))
-
 	// Initial start
 	go start(serverChan, errorChan)
 
@@ -115,7 +300,7 @@ func main() {
 			// Watch for shutdown signals
 			case <-shutdownChan:
 				log.Println("received SIGINT")
-				stop(serverChan, errorChan)
+				stop(serverChan, errorChan, true)
 				doneChan <- true
 			}
 		}
@@ -2,8 +2,29 @@ package metrics
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/MAKLs/nextcloud-exporter/version"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/common/model"
+)
+
+// Stages of a scrape at which errors are counted
+const (
+	StageRequest = "request"
+	StageHTTP    = "http"
+	StageDecode  = "decode"
+	StageCollect = "collect"
+)
+
+// Reasons samples are dropped instead of sent through remote_write
+const (
+	DropQueueFull        = "queue_full"
+	DropRejected         = "rejected"
+	DropRetriesExhausted = "retries_exhausted"
+	DropShutdown         = "shutdown"
 )
 
 const (
@@ -11,11 +32,13 @@ const (
 	exporterSubsystem = "exporter"
 	MetricTag         = "metric"
 	LabelValueTag     = "label"
+	InfoTag           = "info"
+	InfoLabelTag      = "infolabel"
+	CreatedTag        = "created"
 )
 
 var (
-	ExporterRegistry  *prometheus.Registry      = prometheus.NewRegistry()
-	MetricsCollection *MetricTemplateCollection = &MetricTemplateCollection{templates: make(map[string]metricTemplate)}
+	MetricsCollection *MetricTemplateCollection = &MetricTemplateCollection{templates: make(map[string]MetricTemplate)}
 )
 
 func init() {
@@ -23,6 +46,91 @@ func init() {
 		template := newMetricTemplate(name, metricInfo.help, metricInfo.valueType, metricInfo.variableLabels, metricInfo.constLabels)
 		MetricsCollection.mustAddTemplate(name, template)
 	}
+
+	BuildInfo.WithLabelValues(version.Version, version.Revision, version.GoVersion).Set(1)
+	for _, stage := range []string{StageRequest, StageHTTP, StageDecode, StageCollect} {
+		ScrapeErrors.WithLabelValues(stage)
+	}
+	for _, kind := range ChangeKinds {
+		ChangeEvents.WithLabelValues(kind)
+	}
+	for _, reason := range []string{DropQueueFull, DropRejected, DropRetriesExhausted, DropShutdown} {
+		RemoteWriteSamplesDropped.WithLabelValues(reason)
+	}
+}
+
+// Collectors for the exporter process and Go runtime.
+// New ones are built for every registry, so they carry its const labels
+func RuntimeCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	}
+}
+
+// Label names added to the series of histograms and summaries
+var seriesLabels = []string{"le", "quantile"}
+
+// Collector that only describes metrics, so their descriptors can be checked
+type describer func(ch chan<- *prometheus.Desc)
+
+func (d describer) Describe(ch chan<- *prometheus.Desc) {
+	d(ch)
+}
+
+func (d describer) Collect(ch chan<- prometheus.Metric) {}
+
+// Check that const labels can be attached to every exported metric.
+// Their names must be valid and must not clash with the labels of any metric
+func CheckConstLabels(labels map[string]string) error {
+	for name := range labels {
+		if !model.LabelName(name).IsValidLegacy() {
+			return fmt.Errorf("invalid label name '%s'", name)
+		}
+		if strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return fmt.Errorf("label name '%s' uses the reserved prefix '%s'", name, model.ReservedLabelPrefix)
+		}
+		for _, reserved := range seriesLabels {
+			if name == reserved {
+				return fmt.Errorf("label name '%s' is reserved for histogram and summary series", name)
+			}
+		}
+	}
+
+	registerer := prometheus.WrapRegistererWith(labels, prometheus.NewRegistry())
+	for _, collector := range append(RuntimeCollectors(), describer(MetricsCollection.Describe), describer(describeSelfMetrics)) {
+		if err := registerer.Register(collector); err != nil {
+			return fmt.Errorf("labels clash with the labels of an exported metric: %v", err)
+		}
+	}
+	return nil
+}
+
+// Metrics about the exporter itself
+func SelfMetrics() []prometheus.Collector {
+	return []prometheus.Collector{
+		ScrapeCount,
+		ScrapeDuration,
+		NcUp,
+		LastSuccessfulScrape,
+		ScrapeErrors,
+		ScrapeErrorReason,
+		BreakerState,
+		DecodeWarnings,
+		ChangeEvents,
+		LastChange,
+		RemoteWriteSamplesSent,
+		RemoteWriteSamplesDropped,
+		RemoteWritePendingSamples,
+		UnmappedFields,
+		BuildInfo,
+	}
+}
+
+func describeSelfMetrics(ch chan<- *prometheus.Desc) {
+	for _, metric := range SelfMetrics() {
+		metric.Describe(ch)
+	}
 }
 
 // Exporter metrics
@@ -36,15 +144,100 @@ var (
 	ScrapeCount = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: Namespace,
 		Subsystem: exporterSubsystem,
-		Name:      "scrape_count",
-		Help:      "Count of scrapes partitioned by response code.",
-	}, []string{"status_code"})
+		Name:      "scrapes_total",
+		Help:      "Count of scrapes partitioned by HTTP and OCS response code.",
+	}, []string{"status_code", "ocs_status_code"})
 	NcUp = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: Namespace,
 		Subsystem: exporterSubsystem,
 		Name:      "up",
 		Help:      "Flag indicating whether last scrape was successful.",
 	})
+	LastSuccessfulScrape = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: exporterSubsystem,
+		Name:      "last_successful_scrape_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful scrape.",
+	})
+	ScrapeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: exporterSubsystem,
+		Name:      "errors_total",
+		Help:      "Count of scrape errors partitioned by the stage at which they occurred.",
+	}, []string{"stage"})
+	ScrapeErrorReason = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: exporterSubsystem,
+		Name:      "scrape_error",
+		Help:      "Flag indicating the reason the last scrape failed, if any.",
+	}, []string{"reason"})
+	BreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: exporterSubsystem,
+		Name:      "circuit_breaker_state",
+		Help:      "Flag indicating the current state of the circuit breaker towards Nextcloud.",
+	}, []string{"state"})
+	DecodeWarnings = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: exporterSubsystem,
+		Name:      "decode_warnings_total",
+		Help:      "Count of values that couldn't be decoded and were left out, partitioned by field.",
+	}, []string{"field"})
+	UnmappedFields = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: exporterSubsystem,
+		Name:      "unmapped_fields",
+		Help:      "Number of fields in the last serverinfo response not mapped to any metric, in strict decode mode.",
+	})
+	RemoteWriteSamplesSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: exporterSubsystem,
+		Name:      "remote_write_samples_sent_total",
+		Help:      "Count of samples sent through remote_write.",
+	})
+	RemoteWriteSamplesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: exporterSubsystem,
+		Name:      "remote_write_samples_dropped_total",
+		Help:      "Count of samples dropped without being sent through remote_write, partitioned by reason.",
+	}, []string{"reason"})
+	RemoteWritePendingSamples = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: exporterSubsystem,
+		Name:      "remote_write_pending_samples",
+		Help:      "Number of samples queued for remote_write.",
+	})
+	BuildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: exporterSubsystem,
+		Name:      "build_info",
+		Help:      "Build information of this exporter.",
+	}, []string{"version", "revision", "goversion"})
+)
+
+// Kinds of change detected between consecutive server info responses
+const (
+	ChangeVersion          = "version_change"
+	ChangeDebugEnabled     = "debug_enabled"
+	ChangePreviewsToggled  = "previews_toggled"
+	ChangeOpcacheRestart   = "opcache_restart"
+	ChangeAppUpdatesGrowth = "app_updates_available"
+)
+
+var ChangeKinds = []string{ChangeVersion, ChangeDebugEnabled, ChangePreviewsToggled, ChangeOpcacheRestart, ChangeAppUpdatesGrowth}
+
+// Change event metrics
+var (
+	ChangeEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "change_events_total",
+		Help:      "Count of changes detected on this instance between scrapes, partitioned by kind.",
+	}, []string{"kind"})
+	LastChange = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "last_change_timestamp_seconds",
+		Help:      "Unix timestamp of the last change detected on this instance, partitioned by kind.",
+	}, []string{"kind"})
 )
 
 // Nextcloud metrics
@@ -54,6 +247,24 @@ var ncMetrics = map[string]struct {
 	variableLabels []string
 	constLabels    prometheus.Labels
 }{
+	"build_info": {
+		help:           "Versions of the software running this instance.",
+		valueType:      prometheus.GaugeValue,
+		variableLabels: []string{"version", "php_version", "database_type", "database_version", "webserver"},
+		constLabels:    nil,
+	},
+	"memcache_info": {
+		help:           "Types of memcache configured for this instance.",
+		valueType:      prometheus.GaugeValue,
+		variableLabels: []string{"local", "distributed", "locking"},
+		constLabels:    nil,
+	},
+	"php_apcu_cache_info": {
+		help:           "PHP APCU cache configuration.",
+		valueType:      prometheus.GaugeValue,
+		variableLabels: []string{"memory_type"},
+		constLabels:    nil,
+	},
 	"nc_version": {
 		help:           "Version of Nextcloud installed on this instance.",
 		valueType:      prometheus.UntypedValue,
@@ -243,35 +454,35 @@ var ncMetrics = map[string]struct {
 	},
 	"php_opcache_interned_strings_count": {
 		help:           "Count of interned strings in PHP OPcache interned strings buffer.",
-		valueType:      prometheus.CounterValue,
+		valueType:      prometheus.GaugeValue,
 		variableLabels: nil,
 		constLabels:    nil,
 	},
 	"php_opcache_cached_scripts_count": {
 		help:           "Count of cached scripts in PHP OPcache.",
-		valueType:      prometheus.CounterValue,
+		valueType:      prometheus.GaugeValue,
 		variableLabels: nil,
 		constLabels:    nil,
 	},
 	"php_opcache_cached_keys_count": {
 		help:           "Count of cached scripts in PHP OPcache.",
-		valueType:      prometheus.CounterValue,
+		valueType:      prometheus.GaugeValue,
 		variableLabels: nil,
 		constLabels:    nil,
 	},
-	"php_opcache_hits_count": {
+	"php_opcache_hits_total": {
 		help:           "Count of PHP OPcache hits.",
 		valueType:      prometheus.CounterValue,
 		variableLabels: nil,
 		constLabels:    nil,
 	},
-	"php_opcache_misses_count": {
+	"php_opcache_misses_total": {
 		help:           "Count of PHP OPcache misses.",
 		valueType:      prometheus.CounterValue,
 		variableLabels: nil,
 		constLabels:    nil,
 	},
-	"php_opcache_blacklist_misses_count": {
+	"php_opcache_blacklist_misses_total": {
 		help:           "Count of PHP OPcache blacklist misses.",
 		valueType:      prometheus.CounterValue,
 		variableLabels: nil,
@@ -279,22 +490,40 @@ var ncMetrics = map[string]struct {
 	},
 	"php_opcache_start_time_ticks": {
 		help:           "Start time of PHP OPcache.",
-		valueType:      prometheus.CounterValue,
+		valueType:      prometheus.GaugeValue,
 		variableLabels: nil,
 		constLabels:    nil,
 	},
 	"php_opcache_last_restart_time_ticks": {
 		help:           "Last restart time of PHP OPcache.",
-		valueType:      prometheus.CounterValue,
+		valueType:      prometheus.GaugeValue,
 		variableLabels: nil,
 		constLabels:    nil,
 	},
-	"php_opcache_restart_count": {
+	"php_opcache_restarts_total": {
 		help:           "Count of PHP OPcache restarts, partitioned by restart type.",
 		valueType:      prometheus.CounterValue,
 		variableLabels: []string{"restart_type"},
 		constLabels:    nil,
 	},
+	"php_opcache_hit_rate_percent": {
+		help:           "Hit rate of PHP OPcache as reported by PHP.",
+		valueType:      prometheus.GaugeValue,
+		variableLabels: nil,
+		constLabels:    nil,
+	},
+	"php_opcache_blacklist_miss_percent": {
+		help:           "Blacklist miss ratio of PHP OPcache as reported by PHP.",
+		valueType:      prometheus.GaugeValue,
+		variableLabels: nil,
+		constLabels:    nil,
+	},
+	"php_opcache_memory_wasted_percent": {
+		help:           "Percentage of PHP OPcache memory currently wasted.",
+		valueType:      prometheus.GaugeValue,
+		variableLabels: nil,
+		constLabels:    nil,
+	},
 	"php_jit_enabled": {
 		help:           "Flag indicating whether PHP JIT is enabled.",
 		valueType:      prometheus.UntypedValue,
@@ -349,25 +578,25 @@ var ncMetrics = map[string]struct {
 		variableLabels: nil,
 		constLabels:    nil,
 	},
-	"php_apcu_cache_hits_count": {
+	"php_apcu_cache_hits_total": {
 		help:           "Count of PHP APCU cache hits.",
 		valueType:      prometheus.CounterValue,
 		variableLabels: nil,
 		constLabels:    nil,
 	},
-	"php_apcu_cache_misses_count": {
+	"php_apcu_cache_misses_total": {
 		help:           "Count of PHP APCU cache misses.",
 		valueType:      prometheus.CounterValue,
 		variableLabels: nil,
 		constLabels:    nil,
 	},
-	"php_apcu_cache_inserts_count": {
+	"php_apcu_cache_inserts_total": {
 		help:           "Count of PHP APCU cache inserts.",
 		valueType:      prometheus.CounterValue,
 		variableLabels: nil,
 		constLabels:    nil,
 	},
-	"php_apcu_cache_expunges_count": {
+	"php_apcu_cache_expunges_total": {
 		help:           "Count of PHP APCU cache expunges.",
 		valueType:      prometheus.CounterValue,
 		variableLabels: nil,
@@ -381,7 +610,7 @@ var ncMetrics = map[string]struct {
 	},
 	"php_apcu_cache_start_time_ticks": {
 		help:           "Start time of PHP APCU cache.",
-		valueType:      prometheus.CounterValue,
+		valueType:      prometheus.GaugeValue,
 		variableLabels: nil,
 		constLabels:    nil,
 	},
@@ -433,6 +662,42 @@ var ncMetrics = map[string]struct {
 		variableLabels: nil,
 		constLabels:    nil,
 	},
+	"system_memory_utilization_ratio": {
+		help:           "Ratio of system memory in use on this instance.",
+		valueType:      prometheus.GaugeValue,
+		variableLabels: nil,
+		constLabels:    nil,
+	},
+	"shares_link_no_password_ratio": {
+		help:           "Ratio of link shares without a password.",
+		valueType:      prometheus.GaugeValue,
+		variableLabels: nil,
+		constLabels:    nil,
+	},
+	"php_opcache_hit_ratio": {
+		help:           "Ratio of PHP OPcache hits to lookups.",
+		valueType:      prometheus.GaugeValue,
+		variableLabels: nil,
+		constLabels:    nil,
+	},
+	"php_opcache_memory_utilization_ratio": {
+		help:           "Ratio of PHP OPcache memory in use.",
+		valueType:      prometheus.GaugeValue,
+		variableLabels: nil,
+		constLabels:    nil,
+	},
+	"php_opcache_interned_strings_utilization_ratio": {
+		help:           "Ratio of PHP OPcache interned strings buffer in use.",
+		valueType:      prometheus.GaugeValue,
+		variableLabels: nil,
+		constLabels:    nil,
+	},
+	"php_apcu_cache_hit_ratio": {
+		help:           "Ratio of PHP APCU cache hits to lookups.",
+		valueType:      prometheus.GaugeValue,
+		variableLabels: nil,
+		constLabels:    nil,
+	},
 	"active_users": {
 		help:           "Number of active users on this instance, partitioned by last t time.",
 		valueType:      prometheus.GaugeValue,
@@ -441,13 +706,16 @@ var ncMetrics = map[string]struct {
 	},
 }
 
-type metricTemplate struct {
-	Desc      *prometheus.Desc
-	ValueType prometheus.ValueType
+type MetricTemplate struct {
+	Desc           *prometheus.Desc
+	ValueType      prometheus.ValueType
+	FQName         string
+	Help           string
+	VariableLabels []string
 }
 
 type MetricTemplateCollection struct {
-	templates map[string]metricTemplate
+	templates map[string]MetricTemplate
 }
 
 func (col *MetricTemplateCollection) Describe(ch chan<- *prometheus.Desc) {
@@ -456,7 +724,7 @@ func (col *MetricTemplateCollection) Describe(ch chan<- *prometheus.Desc) {
 	}
 }
 
-func (store *MetricTemplateCollection) mustAddTemplate(key string, template metricTemplate) {
+func (store *MetricTemplateCollection) mustAddTemplate(key string, template MetricTemplate) {
 	if _, ok := store.templates[key]; !ok {
 		// Template not present... add it
 		store.templates[key] = template
@@ -466,19 +734,31 @@ func (store *MetricTemplateCollection) mustAddTemplate(key string, template metr
 	}
 }
 
-func (store *MetricTemplateCollection) WithName(name string) (metricTemplate, bool) {
+func (store *MetricTemplateCollection) WithName(name string) (MetricTemplate, bool) {
 	template, ok := store.templates[name]
 	return template, ok
 }
 
-func newMetricTemplate(name string, help string, valueType prometheus.ValueType, variableLabels []string, constLabels prometheus.Labels) metricTemplate {
+func newMetricTemplate(name string, help string, valueType prometheus.ValueType, variableLabels []string, constLabels prometheus.Labels) MetricTemplate {
 	fqName := prometheus.BuildFQName(Namespace, "", name)
-	return metricTemplate{
-		Desc:      prometheus.NewDesc(fqName, help, variableLabels, constLabels),
-		ValueType: valueType,
+	return MetricTemplate{
+		Desc:           prometheus.NewDesc(fqName, help, variableLabels, constLabels),
+		ValueType:      valueType,
+		FQName:         fqName,
+		Help:           help,
+		VariableLabels: variableLabels,
 	}
 }
 
-func (template *metricTemplate) MustEmitMetric(value float64, labelValues ...string) prometheus.Metric {
+func (template *MetricTemplate) MustEmitMetric(value float64, labelValues ...string) prometheus.Metric {
 	return prometheus.MustNewConstMetric(template.Desc, template.ValueType, value, labelValues...)
 }
+
+// Emit a counter with its created timestamp set
+func (template *MetricTemplate) MustEmitMetricWithCreated(value float64, created time.Time, labelValues ...string) prometheus.Metric {
+	metric, err := prometheus.NewConstMetricWithCreatedTimestamp(template.Desc, template.ValueType, value, created, labelValues...)
+	if err != nil {
+		panic(err)
+	}
+	return metric
+}
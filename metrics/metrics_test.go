@@ -0,0 +1,34 @@
+package metrics
+
+import "testing"
+
+func TestCheckConstLabels(t *testing.T) {
+	tests := []struct {
+		labels map[string]string
+		valid  bool
+	}{
+		{nil, true},
+		{map[string]string{"environment": "prod", "cluster": "eu-1"}, true},
+		{map[string]string{"foo-bar": "x"}, false},
+		{map[string]string{"1st": "x"}, false},
+		{map[string]string{"__name__": "x"}, false},
+		// Histogram and summary series
+		{map[string]string{"le": "x"}, false},
+		{map[string]string{"quantile": "x"}, false},
+		// Labels of exporter metrics
+		{map[string]string{"stage": "x"}, false},
+		{map[string]string{"reason": "x"}, false},
+		{map[string]string{"kind": "x"}, false},
+		// Labels of Nextcloud metrics
+		{map[string]string{"cache_type": "x"}, false},
+		// go_info carries the Go version
+		{map[string]string{"version": "x"}, false},
+	}
+
+	for _, test := range tests {
+		err := CheckConstLabels(test.labels)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("%v: valid = %v, want %v (%v)", test.labels, valid, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Alternate spellings of keys, mapped to the spelling used by the models.
+// Older releases and earlier versions of this exporter used these
+var keyAliases = map[string]string{
+	"memcahe.locking": "memcache.locking",
+	"jiit":            "jit",
+	"waster_memory":   "wasted_memory",
+}
+
+func canonicalKey(key string) string {
+	if canonical, ok := keyAliases[key]; ok {
+		return canonical
+	}
+	return key
+}
+
+// Rename aliased keys throughout decoded JSON
+func normalizeKeys(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(val))
+		for key, child := range val {
+			normalized[canonicalKey(key)] = normalizeKeys(child)
+		}
+		return normalized
+	case []interface{}:
+		for i, child := range val {
+			val[i] = normalizeKeys(child)
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+// Token reader renaming aliased XML elements.
+// Replays the already consumed start element, then reads up to its end element
+type aliasTokenReader struct {
+	d     *xml.Decoder
+	start *xml.StartElement
+	depth int
+}
+
+func (r *aliasTokenReader) Token() (xml.Token, error) {
+	if r.start != nil {
+		start := *r.start
+		r.start = nil
+		r.depth++
+		return start, nil
+	}
+	if r.depth == 0 {
+		return nil, io.EOF
+	}
+
+	tok, err := r.d.Token()
+	switch t := tok.(type) {
+	case xml.StartElement:
+		r.depth++
+		t.Name.Local = canonicalKey(t.Name.Local)
+		return t, err
+	case xml.EndElement:
+		r.depth--
+		t.Name.Local = canonicalKey(t.Name.Local)
+		return t, err
+	default:
+		return tok, err
+	}
+}
+
+// Without methods, so decoding into it doesn't recurse
+type rawNCServerInfo NCServerInfo
+
+func (info *NCServerInfo) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var raw interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return err
+	}
+
+	normalized, err := json.Marshal(normalizeKeys(raw))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(normalized, (*rawNCServerInfo)(info))
+}
+
+// The XML format has no wrapping object, so the root element is the OCS response itself
+func (info *NCServerInfo) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	return xml.NewTokenDecoder(&aliasTokenReader{d: d, start: &start}).Decode(&info.Ocs)
+}
+
+func (ncErr *NCError) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	return d.DecodeElement(&ncErr.Ocs, &start)
+}
+
+// Numeric value that may be encoded as a JSON number or string
+type Number float64
+
+func parseNumber(s string) (Number, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return Number(value), nil
+}
+
+func (n *Number) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	switch val := raw.(type) {
+	case nil:
+		return nil
+	case float64:
+		*n = Number(val)
+	case bool:
+		*n = Number(boolToFloat(val))
+	case string:
+		value, err := parseNumber(val)
+		if err != nil {
+			return err
+		}
+		*n = value
+	default:
+		return fmt.Errorf("invalid number %s", data)
+	}
+	return nil
+}
+
+func (n *Number) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var s string
+	if err := d.DecodeElement(&s, &start); err != nil {
+		return err
+	}
+
+	value, err := parseNumber(s)
+	if err != nil {
+		return err
+	}
+	*n = value
+	return nil
+}
+
+// Flag that may be encoded as a JSON bool, number or string such as "yes"/"no"
+type Bool bool
+
+func stringToBool(s string) bool {
+	var result bool
+
+	switch s = strings.ToLower(strings.TrimSpace(s)); {
+	case s == "yes", s == "true", s == "1", s == "on":
+		result = true
+	case s == "no", s == "false", s == "0", s == "off":
+		result = false
+	default:
+		result = false
+	}
+
+	return result
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (b *Bool) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	switch val := raw.(type) {
+	case nil:
+		return nil
+	case bool:
+		*b = Bool(val)
+	case float64:
+		*b = val != 0
+	case string:
+		*b = Bool(stringToBool(val))
+	default:
+		return fmt.Errorf("invalid flag %s", data)
+	}
+	return nil
+}
+
+// PHP renders true as "1" and false as an empty element in XML
+func (b *Bool) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var s string
+	if err := d.DecodeElement(&s, &start); err != nil {
+		return err
+	}
+
+	*b = Bool(stringToBool(s))
+	return nil
+}
+
+// CPU load may be a list of numbers or strings, or `false` where unavailable
+func (load *CPULoad) fromList(values []Number) {
+	averages := []*float64{&load.OneMinuteAverage, &load.FiveMinuteAverage, &load.FifteenMinuteAverage}
+	for i := 0; i < len(values) && i < len(averages); i++ {
+		*averages[i] = float64(values[i])
+	}
+}
+
+func (load *CPULoad) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	if _, ok := raw.([]interface{}); !ok {
+		// Unavailable
+		return nil
+	}
+
+	var values []Number
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	load.fromList(values)
+	return nil
+}
+
+func (load *CPULoad) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var elements struct {
+		Values []Number `xml:"element"`
+	}
+	if err := d.DecodeElement(&elements, &start); err != nil {
+		return err
+	}
+
+	load.fromList(elements.Values)
+	return nil
+}
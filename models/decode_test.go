@@ -0,0 +1,445 @@
+package models
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func decodeFixture(t *testing.T, name string) NCServerInfo {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var info NCServerInfo
+	if strings.HasSuffix(name, ".xml") {
+		err = xml.Unmarshal(data, &info)
+	} else {
+		err = json.Unmarshal(data, &info)
+	}
+	if err != nil {
+		t.Fatalf("failed to decode %s: %v", name, err)
+	}
+	return info
+}
+
+func TestDecodeFixtures(t *testing.T) {
+	tests := []struct {
+		version         string
+		statusCode      uint64
+		memcacheLocking string
+		freeSpace       Number
+		numFiles        Number
+		sharesLink      Number
+		cpuLoad         CPULoad
+		appUpdates      int
+		hits            Number
+		wastedMemory    Number
+		jitEnabled      Bool
+		jitBufferSize   Number
+		databaseSize    Number
+		active24Hours   Number
+	}{
+		{
+			version:         "20.0.14.2",
+			statusCode:      100,
+			memcacheLocking: "none",
+			freeSpace:       21474836480,
+			numFiles:        52318,
+			sharesLink:      7,
+			cpuLoad:         CPULoad{0.62, 0.41, 0.35},
+			appUpdates:      0,
+			hits:            5830731,
+			wastedMemory:    66816,
+			databaseSize:    126877696,
+			active24Hours:   5,
+		},
+		{
+			version:         "25.0.13.2",
+			statusCode:      100,
+			memcacheLocking: `\OC\Memcache\Redis`,
+			freeSpace:       96417890304,
+			numFiles:        402117,
+			sharesLink:      41,
+			cpuLoad:         CPULoad{1.24, 0.98, 0.87},
+			appUpdates:      2,
+			hits:            19227491,
+			wastedMemory:    10000,
+			databaseSize:    498532911,
+			active24Hours:   24,
+		},
+		{
+			version:         "28.0.4.1",
+			statusCode:      200,
+			memcacheLocking: `\OC\Memcache\Redis`,
+			freeSpace:       412316860416,
+			numFiles:        2871553,
+			sharesLink:      493,
+			cpuLoad:         CPULoad{0.43, 0.31, 0.27},
+			appUpdates:      1,
+			hits:            87215063,
+			wastedMemory:    1012000,
+			jitEnabled:      true,
+			jitBufferSize:   134217712,
+			databaseSize:    2190475264,
+			active24Hours:   112,
+		},
+	}
+
+	for _, test := range tests {
+		major := strings.Split(test.version, ".")[0]
+		for _, format := range []string{"json", "xml"} {
+			name := "nc" + major + "." + format
+			t.Run(name, func(t *testing.T) {
+				info := decodeFixture(t, name)
+
+				statusCode := test.statusCode
+				if format == "json" {
+					// The JSON fixtures are OCS v2 responses
+					statusCode = ocsV2StatusOK
+				}
+				if meta := info.Ocs.Meta; !meta.IsOK() || meta.StatusCode != statusCode {
+					t.Errorf("meta = %+v, want ok with status code %d", meta, statusCode)
+				}
+
+				system := info.Ocs.Data.NextCloud.System
+				if system.Version != test.version {
+					t.Errorf("version = %q, want %q", system.Version, test.version)
+				}
+				if system.MemcacheLocking != test.memcacheLocking {
+					t.Errorf("memcache.locking = %q, want %q", system.MemcacheLocking, test.memcacheLocking)
+				}
+				if !system.EnableAvatars || system.Debug {
+					t.Errorf("enable_avatars = %v, debug = %v, want true, false", system.EnableAvatars, system.Debug)
+				}
+				if system.FreeSpace != test.freeSpace {
+					t.Errorf("freespace = %v, want %v", system.FreeSpace, test.freeSpace)
+				}
+				if system.CPULoad != test.cpuLoad {
+					t.Errorf("cpuload = %+v, want %+v", system.CPULoad, test.cpuLoad)
+				}
+				if system.Apps.NumUpdatesAvailable != Number(test.appUpdates) {
+					t.Errorf("num_updates_available = %v, want %d", system.Apps.NumUpdatesAvailable, test.appUpdates)
+				}
+
+				if numFiles := info.Ocs.Data.NextCloud.Storage.NumFiles; numFiles != test.numFiles {
+					t.Errorf("num_files = %v, want %v", numFiles, test.numFiles)
+				}
+				if sharesLink := info.Ocs.Data.NextCloud.Shares.NumSharesLink; sharesLink != test.sharesLink {
+					t.Errorf("num_shares_link = %v, want %v", sharesLink, test.sharesLink)
+				}
+
+				opcache := info.Ocs.Data.Server.PHP.Opcache
+				if !opcache.OpcacheEnabled || opcache.CacheFull {
+					t.Errorf("opcache_enabled = %v, cache_full = %v, want true, false", opcache.OpcacheEnabled, opcache.CacheFull)
+				}
+				if opcache.OpcacheStatistics.Hits != test.hits {
+					t.Errorf("opcache hits = %v, want %v", opcache.OpcacheStatistics.Hits, test.hits)
+				}
+				if opcache.MemoryUsage.WastedMemory != test.wastedMemory {
+					t.Errorf("wasted_memory = %v, want %v", opcache.MemoryUsage.WastedMemory, test.wastedMemory)
+				}
+				if opcache.JIT.Enabled != test.jitEnabled || opcache.JIT.BufferSize != test.jitBufferSize {
+					t.Errorf("jit = %+v, want enabled %v with buffer size %v", opcache.JIT, test.jitEnabled, test.jitBufferSize)
+				}
+
+				database := info.Ocs.Data.Server.Database
+				if database.Size == nil || *database.Size != test.databaseSize {
+					t.Errorf("database size = %v, want %v", database.Size, test.databaseSize)
+				}
+
+				if active := info.Ocs.Data.ActiveUsers.Last24Hours; active != test.active24Hours {
+					t.Errorf("last24hours = %v, want %v", active, test.active24Hours)
+				}
+			})
+		}
+	}
+}
+
+func TestUnmappedFieldsFixtures(t *testing.T) {
+	tests := map[string][]string{
+		"nc20.json": {"ocs.data.nextcloud.shares.permissions_0_1", "ocs.data.nextcloud.shares.permissions_3_1", "ocs.data.nextcloud.shares.permissions_3_31"},
+		"nc28.json": {"ocs.data.nextcloud.system.cpunum", "ocs.data.nextcloud.system.update"},
+	}
+
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			unmapped, err := UnmappedFields(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := make(map[string]bool, len(unmapped))
+			for _, path := range unmapped {
+				found[path] = true
+			}
+			for _, path := range want {
+				if !found[path] {
+					t.Errorf("%s not reported as unmapped, got %v", path, unmapped)
+				}
+			}
+		})
+	}
+}
+
+func TestNumberEncodings(t *testing.T) {
+	tests := []struct {
+		json string
+		want Number
+	}{
+		{`42`, 42},
+		{`4.5`, 4.5},
+		{`"42"`, 42},
+		{`" 42 "`, 42},
+		{`"4.5"`, 4.5},
+		{`""`, 0},
+		{`null`, 0},
+		{`true`, 1},
+		{`false`, 0},
+	}
+
+	for _, test := range tests {
+		var n Number
+		if err := json.Unmarshal([]byte(test.json), &n); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.json, err)
+			continue
+		}
+		if n != test.want {
+			t.Errorf("%s = %v, want %v", test.json, n, test.want)
+		}
+	}
+
+	for _, invalid := range []string{`"12 MB"`, `[1]`, `{}`} {
+		var n Number
+		if err := json.Unmarshal([]byte(invalid), &n); err == nil {
+			t.Errorf("%s: expected an error, got %v", invalid, n)
+		}
+	}
+}
+
+func TestNumberXML(t *testing.T) {
+	tests := map[string]Number{
+		`<n>42</n>`:    42,
+		`<n> 4.5 </n>`: 4.5,
+		`<n></n>`:      0,
+		`<n/>`:         0,
+	}
+
+	for data, want := range tests {
+		var n Number
+		if err := xml.Unmarshal([]byte(data), &n); err != nil {
+			t.Errorf("%s: unexpected error: %v", data, err)
+			continue
+		}
+		if n != want {
+			t.Errorf("%s = %v, want %v", data, n, want)
+		}
+	}
+}
+
+func TestBoolEncodings(t *testing.T) {
+	tests := []struct {
+		json string
+		want Bool
+	}{
+		{`true`, true},
+		{`false`, false},
+		{`"yes"`, true},
+		{`"no"`, false},
+		{`"Yes"`, true},
+		{`"on"`, true},
+		{`"off"`, false},
+		{`"1"`, true},
+		{`"0"`, false},
+		{`1`, true},
+		{`0`, false},
+		{`""`, false},
+	}
+
+	for _, test := range tests {
+		var b Bool
+		if err := json.Unmarshal([]byte(test.json), &b); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.json, err)
+			continue
+		}
+		if b != test.want {
+			t.Errorf("%s = %v, want %v", test.json, b, test.want)
+		}
+	}
+
+	// PHP renders false as an empty element
+	for data, want := range map[string]Bool{`<b>1</b>`: true, `<b></b>`: false, `<b/>`: false, `<b>yes</b>`: true} {
+		var b Bool
+		if err := xml.Unmarshal([]byte(data), &b); err != nil {
+			t.Errorf("%s: unexpected error: %v", data, err)
+			continue
+		}
+		if b != want {
+			t.Errorf("%s = %v, want %v", data, b, want)
+		}
+	}
+}
+
+func TestKeyAliases(t *testing.T) {
+	const data = `{"ocs":{"meta":{"status":"ok","statuscode":200},"data":{
+		"nextcloud":{"system":{"memcahe.locking":"\\OC\\Memcache\\Redis"}},
+		"server":{"php":{"opcache":{
+			"memory_usage":{"waster_memory":1234},
+			"jiit":{"enabled":true,"buffer_size":"5678"}
+		}}}
+	}}}`
+
+	var info NCServerInfo
+	if err := json.Unmarshal([]byte(data), &info); err != nil {
+		t.Fatal(err)
+	}
+	assertAliases(t, info)
+
+	unmapped, err := UnmappedFields([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unmapped) != 0 {
+		t.Errorf("aliased keys reported as unmapped: %v", unmapped)
+	}
+}
+
+func TestKeyAliasesXML(t *testing.T) {
+	const data = `<?xml version="1.0"?>
+<ocs>
+ <meta><status>ok</status><statuscode>100</statuscode></meta>
+ <data>
+  <nextcloud><system><memcahe.locking>\OC\Memcache\Redis</memcahe.locking></system></nextcloud>
+  <server><php><opcache>
+   <memory_usage><waster_memory>1234</waster_memory></memory_usage>
+   <jiit><enabled>1</enabled><buffer_size>5678</buffer_size></jiit>
+  </opcache></php></server>
+ </data>
+</ocs>`
+
+	var info NCServerInfo
+	if err := xml.Unmarshal([]byte(data), &info); err != nil {
+		t.Fatal(err)
+	}
+	assertAliases(t, info)
+}
+
+func assertAliases(t *testing.T, info NCServerInfo) {
+	t.Helper()
+
+	if locking := info.Ocs.Data.NextCloud.System.MemcacheLocking; locking != `\OC\Memcache\Redis` {
+		t.Errorf("memcache.locking = %q, want it decoded from memcahe.locking", locking)
+	}
+	opcache := info.Ocs.Data.Server.PHP.Opcache
+	if opcache.MemoryUsage.WastedMemory != 1234 {
+		t.Errorf("wasted_memory = %v, want it decoded from waster_memory", opcache.MemoryUsage.WastedMemory)
+	}
+	if !opcache.JIT.Enabled || opcache.JIT.BufferSize != 5678 {
+		t.Errorf("jit = %+v, want it decoded from jiit", opcache.JIT)
+	}
+}
+
+func TestCPULoad(t *testing.T) {
+	tests := []struct {
+		json string
+		want CPULoad
+	}{
+		{`false`, CPULoad{}},
+		{`null`, CPULoad{}},
+		{`[]`, CPULoad{}},
+		{`[0.5]`, CPULoad{0.5, 0, 0}},
+		{`[0.5,0.4]`, CPULoad{0.5, 0.4, 0}},
+		{`[0.5,0.4,0.3]`, CPULoad{0.5, 0.4, 0.3}},
+		{`[0.5,0.4,0.3,0.2]`, CPULoad{0.5, 0.4, 0.3}},
+		{`["0.5","0.4","0.3"]`, CPULoad{0.5, 0.4, 0.3}},
+	}
+
+	for _, test := range tests {
+		var load CPULoad
+		if err := json.Unmarshal([]byte(test.json), &load); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.json, err)
+			continue
+		}
+		if load != test.want {
+			t.Errorf("%s = %+v, want %+v", test.json, load, test.want)
+		}
+	}
+
+	xmlTests := map[string]CPULoad{
+		`<cpuload></cpuload>`: {},
+		`<cpuload/>`:          {},
+		`<cpuload><element>0.5</element></cpuload>`:                                                           {0.5, 0, 0},
+		`<cpuload><element>0.5</element><element>0.4</element><element>0.3</element></cpuload>`:               {0.5, 0.4, 0.3},
+		`<cpuload><element>1</element><element>2</element><element>3</element><element>4</element></cpuload>`: {1, 2, 3},
+	}
+	for data, want := range xmlTests {
+		var load CPULoad
+		if err := xml.Unmarshal([]byte(data), &load); err != nil {
+			t.Errorf("%s: unexpected error: %v", data, err)
+			continue
+		}
+		if load != want {
+			t.Errorf("%s = %+v, want %+v", data, load, want)
+		}
+	}
+}
+
+func TestDatabaseSize(t *testing.T) {
+	size := func(n Number) *Number { return &n }
+
+	tests := []struct {
+		json string
+		want *Number
+	}{
+		{`{"type":"mysql"}`, nil},
+		{`{"type":"mysql","size":null}`, nil},
+		{`{"type":"mysql","size":""}`, nil},
+		{`{"type":"mysql","size":123}`, size(123)},
+		{`{"type":"mysql","size":"123"}`, size(123)},
+		{`{"type":"mysql","size":"12 MB"}`, nil},
+	}
+
+	for _, test := range tests {
+		var db Database
+		if err := json.Unmarshal([]byte(test.json), &db); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.json, err)
+			continue
+		}
+		if db.Type != "mysql" {
+			t.Errorf("%s: type = %q, want mysql", test.json, db.Type)
+		}
+		if (db.Size == nil) != (test.want == nil) || (db.Size != nil && *db.Size != *test.want) {
+			t.Errorf("%s: size = %v, want %v", test.json, db.Size, test.want)
+		}
+	}
+
+	xmlTests := []struct {
+		xml  string
+		want *Number
+	}{
+		{`<database><type>mysql</type></database>`, nil},
+		{`<database><type>mysql</type><size/></database>`, nil},
+		{`<database><type>mysql</type><size>123</size></database>`, size(123)},
+		{`<database><type>mysql</type><size>12 MB</size></database>`, nil},
+	}
+	for _, test := range xmlTests {
+		var db Database
+		if err := xml.Unmarshal([]byte(test.xml), &db); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.xml, err)
+			continue
+		}
+		if (db.Size == nil) != (test.want == nil) || (db.Size != nil && *db.Size != *test.want) {
+			t.Errorf("%s: size = %v, want %v", test.xml, db.Size, test.want)
+		}
+	}
+}
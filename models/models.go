@@ -2,27 +2,16 @@ package models
 
 import (
 	"encoding/json"
-	"strconv"
+	"encoding/xml"
 	"strings"
 )
 
-func stringToBool(s string) bool {
-	var result bool
-
-	switch s = strings.ToLower(s); {
-	case s == "yes", s == "true":
-		result = true
-	case s == "no", s == "false":
-		result = false
-	default:
-		result = false
-	}
-
-	return result
-}
-
 type NCServerInfo struct {
 	Ocs Ocs `json:"ocs"`
+	// JSON paths in the response not mapped to any field, only recorded in strict decode mode
+	Unmapped []string `json:"-" xml:"-"`
+	// Response body the server info was decoded from
+	Raw []byte `json:"-" xml:"-"`
 }
 
 type NCError struct {
@@ -30,101 +19,60 @@ type NCError struct {
 }
 
 type Ocs struct {
-	Meta Meta `json:"meta"`
-	Data Data `json:"data"`
+	Meta Meta `json:"meta" xml:"meta"`
+	Data Data `json:"data" xml:"data"`
 }
 
 type OcsWithError struct {
-	Meta Meta `json:"meta"`
+	Meta Meta `json:"meta" xml:"meta"`
 }
 
 type Meta struct {
-	Status     string `json:"status"`
-	StatusCode uint64 `json:"statuscode"`
-	Message    string `json:"message"`
+	Status     string `json:"status" xml:"status"`
+	StatusCode uint64 `json:"statuscode" xml:"statuscode"`
+	Message    string `json:"message" xml:"message"`
 }
 
-type Data struct {
-	NextCloud   NextCloud   `json:"nextcloud"`
-	Server      Server      `json:"server"`
-	ActiveUsers ActiveUsers `json:"activeUsers"`
-}
+// OCS status codes indicating success.
+// v1 reports 100 and v2 reports 200
+const (
+	ocsV1StatusOK = 100
+	ocsV2StatusOK = 200
+)
 
-type NextCloud struct {
-	System  System  `json:"system"`
-	Storage Storage `json:"storage"`
-	Shares  Shares  `json:"shares"`
+func (meta Meta) IsOK() bool {
+	return strings.ToLower(meta.Status) == "ok" && (meta.StatusCode == ocsV1StatusOK || meta.StatusCode == ocsV2StatusOK)
 }
 
-type System struct {
-	Version             string  `json:"version" metric:"nc_version"`
-	Theme               string  `json:"theme"`
-	EnableAvatars       bool    `json:"enable_avatars" metric:"avatars_enabled"`
-	EnablePreviews      bool    `json:"enable_previews" metric:"previews_enabled"`
-	MemcacheLocal       string  `json:"memcache.local" metric:"memcache_type" label:"local"`
-	MemcacheDistributed string  `json:"memcache.distributed" metric:"memcache_type" label:"distributed"`
-	FileLockingEnabled  bool    `json:"filelocking.enabled" metric:"file_locking_enabled"`
-	MemcacheLocking     string  `json:"memcahe.locking" metric:"memcache_locking_type"`
-	Debug               bool    `json:"debug" metric:"debug_mode_enabled"`
-	FreeSpace           float64 `json:"freespace" metric:"free_space_bytes"`
-	CPULoad             CPULoad `json:"cpuload"`
-	MemTotal            float64 `json:"mem_total"`
-	MemFree             float64 `json:"mem_free"`
-	SwapTotal           float64 `json:"swap_total"`
-	SwapFree            float64 `json:"swap_free"`
-	Apps                Apps    `json:"apps"`
+type Data struct {
+	NextCloud   NextCloud   `json:"nextcloud" xml:"nextcloud"`
+	Server      Server      `json:"server" xml:"server"`
+	ActiveUsers ActiveUsers `json:"activeUsers" xml:"activeUsers"`
 }
 
-// Raw system data returned from API.
-// Requires mapping string "yes"/"no" to bool
-type intermediateSystem struct {
-	Version             string     `json:"version"`
-	Theme               string     `json:"theme"`
-	EnableAvatars       string     `json:"enable_avatars"`
-	EnablePreviews      string     `json:"enable_previews"`
-	MemcacheLocal       string     `json:"memcache.local"`
-	MemcacheDistributed string     `json:"memcache.distributed"`
-	FileLockingEnabled  string     `json:"filelocking.enabled"`
-	MemcacheLocking     string     `json:"memcahe.locking"`
-	Debug               string     `json:"debug"`
-	FreeSpace           float64    `json:"freespace"`
-	CPULoad             [3]float64 `json:"cpuload"`
-	MemTotal            float64    `json:"mem_total"`
-	MemFree             float64    `json:"mem_free"`
-	SwapTotal           float64    `json:"swap_total"`
-	SwapFree            float64    `json:"swap_free"`
-	Apps                Apps       `json:"apps"`
+type NextCloud struct {
+	System  System  `json:"system" xml:"system"`
+	Storage Storage `json:"storage" xml:"storage"`
+	Shares  Shares  `json:"shares" xml:"shares"`
 }
 
-func (sys *System) UnmarshalJSON(data []byte) error {
-	var inter intermediateSystem
-	err := json.Unmarshal(data, &inter)
-	if err != nil {
-		return err
-	}
-
-	sys.Version = inter.Version
-	sys.Theme = inter.Theme
-	sys.EnableAvatars = stringToBool(inter.EnableAvatars)
-	sys.EnablePreviews = stringToBool(inter.EnablePreviews)
-	sys.MemcacheLocal = inter.MemcacheLocal
-	sys.MemcacheDistributed = inter.MemcacheDistributed
-	sys.FileLockingEnabled = stringToBool(inter.FileLockingEnabled)
-	sys.MemcacheLocking = inter.MemcacheLocking
-	sys.Debug = stringToBool(inter.Debug)
-	sys.FreeSpace = inter.FreeSpace
-	sys.CPULoad = CPULoad{
-		OneMinuteAverage:     inter.CPULoad[0],
-		FiveMinuteAverage:    inter.CPULoad[1],
-		FifteenMinuteAverage: inter.CPULoad[2],
-	}
-	sys.MemTotal = inter.MemTotal
-	sys.MemFree = inter.MemFree
-	sys.SwapTotal = inter.SwapTotal
-	sys.SwapFree = inter.SwapFree
-	sys.Apps = inter.Apps
-
-	return nil
+type System struct {
+	Version             string  `json:"version" xml:"version" metric:"nc_version" info:"build_info" infolabel:"version"`
+	Theme               string  `json:"theme" xml:"theme"`
+	EnableAvatars       Bool    `json:"enable_avatars" xml:"enable_avatars" metric:"avatars_enabled"`
+	EnablePreviews      Bool    `json:"enable_previews" xml:"enable_previews" metric:"previews_enabled"`
+	MemcacheLocal       string  `json:"memcache.local" xml:"memcache.local" metric:"memcache_type" label:"local" info:"memcache_info" infolabel:"local"`
+	MemcacheDistributed string  `json:"memcache.distributed" xml:"memcache.distributed" metric:"memcache_type" label:"distributed" info:"memcache_info" infolabel:"distributed"`
+	FileLockingEnabled  Bool    `json:"filelocking.enabled" xml:"filelocking.enabled" metric:"file_locking_enabled"`
+	MemcacheLocking     string  `json:"memcache.locking" xml:"memcache.locking" metric:"memcache_locking_type" info:"memcache_info" infolabel:"locking"`
+	Debug               Bool    `json:"debug" xml:"debug" metric:"debug_mode_enabled"`
+	FreeSpace           Number  `json:"freespace" xml:"freespace" metric:"free_space_bytes"`
+	CPULoad             CPULoad `json:"cpuload" xml:"cpuload"`
+	MemTotal            Number  `json:"mem_total" xml:"mem_total"`
+	MemFree             Number  `json:"mem_free" xml:"mem_free"`
+	SwapTotal           Number  `json:"swap_total" xml:"swap_total"`
+	SwapFree            Number  `json:"swap_free" xml:"swap_free"`
+	Apps                Apps    `json:"apps" xml:"apps"`
 }
 
 type CPULoad struct {
@@ -134,157 +82,189 @@ type CPULoad struct {
 }
 
 type Apps struct {
-	NumInstalled        float64     `json:"num_installed" metric:"installed_apps"`
-	NumUpdatesAvailable float64     `json:"num_updates_available" metric:"app_updates_available"`
-	AppUpdates          interface{} `json:"app_updates"`
+	NumInstalled        Number      `json:"num_installed" xml:"num_installed" metric:"installed_apps"`
+	NumUpdatesAvailable Number      `json:"num_updates_available" xml:"num_updates_available" metric:"app_updates_available"`
+	AppUpdates          interface{} `json:"app_updates" xml:"app_updates"`
 }
 
 type Storage struct {
-	NumUsers         float64 `json:"num_users" metric:"users"`
-	NumFiles         float64 `json:"num_files" metric:"files"`
-	NumStorages      float64 `json:"num_storages"`
-	NumStoragesLocal float64 `json:"num_storages_local" metric:"storages" label:"local"`
-	NumStoragesHome  float64 `json:"num_storages_home" metric:"storages" label:"home"`
-	NumStoragesOther float64 `json:"num_storages_other" metric:"storages" label:"other"`
+	NumUsers         Number `json:"num_users" xml:"num_users" metric:"users"`
+	NumFiles         Number `json:"num_files" xml:"num_files" metric:"files"`
+	NumStorages      Number `json:"num_storages" xml:"num_storages"`
+	NumStoragesLocal Number `json:"num_storages_local" xml:"num_storages_local" metric:"storages" label:"local"`
+	NumStoragesHome  Number `json:"num_storages_home" xml:"num_storages_home" metric:"storages" label:"home"`
+	NumStoragesOther Number `json:"num_storages_other" xml:"num_storages_other" metric:"storages" label:"other"`
 }
 
 type Shares struct {
-	NumShares               float64 `json:"num_shares"`
-	NumSharesUser           float64 `json:"num_shares_user" metric:"shares" label:"user"`
-	NumSharesGroups         float64 `json:"num_shares_groups" metric:"shares" label:"groups"`
-	NumSharesLink           float64 `json:"num_shares_link" metric:"shares" label:"link"`
-	NumSharesMail           float64 `json:"num_shares_mail" metric:"shares" label:"mail"`
-	NumSharesRoom           float64 `json:"num_shares_room" metric:"shares" label:"room"`
-	NumSharesLinkNoPassword float64 `json:"num_shares_link_no_password" metric:"shares" label:"no_password"`
-	NumFedSharesSent        float64 `json:"num_fed_shares_sent" metric:"fed_shares_sent"`
-	NumFedSharesReceived    float64 `json:"num_fed_shares_received" metric:"fed_shares_received"`
+	NumShares               Number `json:"num_shares" xml:"num_shares"`
+	NumSharesUser           Number `json:"num_shares_user" xml:"num_shares_user" metric:"shares" label:"user"`
+	NumSharesGroups         Number `json:"num_shares_groups" xml:"num_shares_groups" metric:"shares" label:"groups"`
+	NumSharesLink           Number `json:"num_shares_link" xml:"num_shares_link" metric:"shares" label:"link"`
+	NumSharesMail           Number `json:"num_shares_mail" xml:"num_shares_mail" metric:"shares" label:"mail"`
+	NumSharesRoom           Number `json:"num_shares_room" xml:"num_shares_room" metric:"shares" label:"room"`
+	NumSharesLinkNoPassword Number `json:"num_shares_link_no_password" xml:"num_shares_link_no_password" metric:"shares" label:"no_password"`
+	NumFedSharesSent        Number `json:"num_fed_shares_sent" xml:"num_fed_shares_sent" metric:"fed_shares_sent"`
+	NumFedSharesReceived    Number `json:"num_fed_shares_received" xml:"num_fed_shares_received" metric:"fed_shares_received"`
 }
 
 type Server struct {
-	WebServer string   `json:"webserver" metric:"web_server_type"`
-	PHP       PHP      `json:"php"`
-	Database  Database `json:"database"`
+	WebServer string   `json:"webserver" xml:"webserver" metric:"web_server_type" info:"build_info" infolabel:"webserver"`
+	PHP       PHP      `json:"php" xml:"php"`
+	Database  Database `json:"database" xml:"database"`
 }
 
 type PHP struct {
-	Version           string  `json:"version" metric:"php_version"`
-	MemoryLimit       float64 `json:"memory_limit" metric:"php_memory_limit_bytes"`
-	MaxExecutionTime  float64 `json:"max_execution_time" metric:"php_max_execution_time_seconds"`
-	UploadMaxFileSize float64 `json:"upload_max_filesize" metric:"php_upload_max_file_size_bytes"`
-	Opcache           Opcache `json:"opcache"`
-	APCU              APCU    `json:"apcu"`
+	Version           string  `json:"version" xml:"version" metric:"php_version" info:"build_info" infolabel:"php_version"`
+	MemoryLimit       Number  `json:"memory_limit" xml:"memory_limit" metric:"php_memory_limit_bytes"`
+	MaxExecutionTime  Number  `json:"max_execution_time" xml:"max_execution_time" metric:"php_max_execution_time_seconds"`
+	UploadMaxFileSize Number  `json:"upload_max_filesize" xml:"upload_max_filesize" metric:"php_upload_max_file_size_bytes"`
+	Opcache           Opcache `json:"opcache" xml:"opcache"`
+	APCU              APCU    `json:"apcu" xml:"apcu"`
 }
 
 type Opcache struct {
-	OpcacheEnabled       bool                 `json:"opcache_enabled" metric:"php_opcache_enabled"`
-	CacheFull            bool                 `json:"cache_full" metric:"php_opcache_full"`
-	RestartPending       bool                 `json:"restart_pending" metric:"php_opcache_restart_pending"`
-	RestartInProgress    bool                 `json:"restart_in_progress" metric:"php_opcache_restart_in_progress"`
-	MemoryUsage          MemoryUsage          `json:"memory_usage"`
-	InternedStringsUsage InternedStringsUsage `json:"interned_strings_usage"`
-	OpcacheStatistics    OpcacheStatistics    `json:"opcache_statistics"`
-	JIT                  JIT                  `json:"jiit"`
+	OpcacheEnabled       Bool                 `json:"opcache_enabled" xml:"opcache_enabled" metric:"php_opcache_enabled"`
+	CacheFull            Bool                 `json:"cache_full" xml:"cache_full" metric:"php_opcache_full"`
+	RestartPending       Bool                 `json:"restart_pending" xml:"restart_pending" metric:"php_opcache_restart_pending"`
+	RestartInProgress    Bool                 `json:"restart_in_progress" xml:"restart_in_progress" metric:"php_opcache_restart_in_progress"`
+	MemoryUsage          MemoryUsage          `json:"memory_usage" xml:"memory_usage"`
+	InternedStringsUsage InternedStringsUsage `json:"interned_strings_usage" xml:"interned_strings_usage"`
+	OpcacheStatistics    OpcacheStatistics    `json:"opcache_statistics" xml:"opcache_statistics"`
+	JIT                  JIT                  `json:"jit" xml:"jit"`
 }
 
 type MemoryUsage struct {
-	UsedMemory              float64 `json:"used_memory" metric:"php_opcache_memory_used_bytes"`
-	FreeMemory              float64 `json:"free_memory" metric:"php_opcache_memory_free_bytes"`
-	WastedMemory            float64 `json:"waster_memory"`
-	CurrentWastedPercentage float64 `json:"current_wasted_percentage"`
+	UsedMemory              Number `json:"used_memory" xml:"used_memory" metric:"php_opcache_memory_used_bytes"`
+	FreeMemory              Number `json:"free_memory" xml:"free_memory" metric:"php_opcache_memory_free_bytes"`
+	WastedMemory            Number `json:"wasted_memory" xml:"wasted_memory" metric:"php_opcache_memory_wasted_bytes"`
+	CurrentWastedPercentage Number `json:"current_wasted_percentage" xml:"current_wasted_percentage" metric:"php_opcache_memory_wasted_percent"`
 }
 
 type InternedStringsUsage struct {
-	BufferSize      float64 `json:"buffer_size" metric:"php_opcache_interned_strings_buffer_size_bytes"`
-	UsedMemory      float64 `json:"used_memory" metric:"php_opcache_interned_strings_memory_used_bytes"`
-	FreeMemory      float64 `json:"free_memory" metric:"php_opcache_interned_strings_memory_free_bytes"`
-	NumberOfStrings float64 `json:"number_of_strings" metric:"php_opcache_interned_strings_count"`
+	BufferSize      Number `json:"buffer_size" xml:"buffer_size" metric:"php_opcache_interned_strings_buffer_size_bytes"`
+	UsedMemory      Number `json:"used_memory" xml:"used_memory" metric:"php_opcache_interned_strings_memory_used_bytes"`
+	FreeMemory      Number `json:"free_memory" xml:"free_memory" metric:"php_opcache_interned_strings_memory_free_bytes"`
+	NumberOfStrings Number `json:"number_of_strings" xml:"number_of_strings" metric:"php_opcache_interned_strings_count"`
 }
 
 type OpcacheStatistics struct {
-	NumCachedScripts   float64 `json:"num_cached_scripts" metric:"php_opcache_cached_scripts_count"`
-	NumCachedKeys      float64 `json:"num_cached_keys" metric:"php_opcache_cached_keys_count"`
-	MaxCachedKeys      float64 `json:"max_cached_keys"`
-	Hits               float64 `json:"hits" metric:"php_opcache_hits_count"`
-	StartTime          float64 `json:"start_time" metric:"php_opcache_start_time_ticks"`
-	LastRestartTime    float64 `json:"last_restart_time" metric:"php_opcache_last_restart_time_ticks"`
-	OOMRestarts        float64 `json:"oom_restarts" metric:"php_opcache_restart_count" label:"oom"`
-	HashRestarts       float64 `json:"hash_restarts" metric:"php_opcache_restart_count" label:"hash"`
-	ManualRestarts     float64 `json:"manual_restarts" metric:"php_opcache_restart_count" label:"manual"`
-	Misses             float64 `json:"misses" metric:"php_opcache_misses_count"`
-	BlacklistMisses    float64 `json:"blacklist_misses" metric:"php_opcache_blacklist_misses_count"`
-	BlacklistMissRatio float64 `json:"blacklist_miss_ratio"`
-	OpcacheHitRate     float64 `json:"opcache_hit_rate"`
+	NumCachedScripts   Number `json:"num_cached_scripts" xml:"num_cached_scripts" metric:"php_opcache_cached_scripts_count"`
+	NumCachedKeys      Number `json:"num_cached_keys" xml:"num_cached_keys" metric:"php_opcache_cached_keys_count"`
+	MaxCachedKeys      Number `json:"max_cached_keys" xml:"max_cached_keys"`
+	Hits               Number `json:"hits" xml:"hits" metric:"php_opcache_hits_total" created:"StartTime"`
+	StartTime          Number `json:"start_time" xml:"start_time" metric:"php_opcache_start_time_ticks"`
+	LastRestartTime    Number `json:"last_restart_time" xml:"last_restart_time" metric:"php_opcache_last_restart_time_ticks"`
+	OOMRestarts        Number `json:"oom_restarts" xml:"oom_restarts" metric:"php_opcache_restarts_total" label:"oom" created:"StartTime"`
+	HashRestarts       Number `json:"hash_restarts" xml:"hash_restarts" metric:"php_opcache_restarts_total" label:"hash" created:"StartTime"`
+	ManualRestarts     Number `json:"manual_restarts" xml:"manual_restarts" metric:"php_opcache_restarts_total" label:"manual" created:"StartTime"`
+	Misses             Number `json:"misses" xml:"misses" metric:"php_opcache_misses_total" created:"StartTime"`
+	BlacklistMisses    Number `json:"blacklist_misses" xml:"blacklist_misses" metric:"php_opcache_blacklist_misses_total" created:"StartTime"`
+	BlacklistMissRatio Number `json:"blacklist_miss_ratio" xml:"blacklist_miss_ratio" metric:"php_opcache_blacklist_miss_percent"`
+	OpcacheHitRate     Number `json:"opcache_hit_rate" xml:"opcache_hit_rate" metric:"php_opcache_hit_rate_percent"`
 }
 
 type JIT struct {
-	Enabled    bool    `json:"enabled" metric:"php_jit_enabled"`
-	On         bool    `json:"on" metric:"php_jit_on"`
-	Kind       float64 `json:"kind" metric:"php_jit_kind"`
-	OptLevel   float64 `json:"opt_level" metric:"php_jit_optimization_level"`
-	OptFlags   float64 `json:"opt_flags" metric:"php_jit_optimization_flags"`
-	BufferSize float64 `json:"buffer_size" metric:"php_jit_buffer_size_bytes"`
-	BufferFree float64 `json:"buffer_free" metric:"php_jit_buffer_free_bytes"`
+	Enabled    Bool   `json:"enabled" xml:"enabled" metric:"php_jit_enabled"`
+	On         Bool   `json:"on" xml:"on" metric:"php_jit_on"`
+	Kind       Number `json:"kind" xml:"kind" metric:"php_jit_kind"`
+	OptLevel   Number `json:"opt_level" xml:"opt_level" metric:"php_jit_optimization_level"`
+	OptFlags   Number `json:"opt_flags" xml:"opt_flags" metric:"php_jit_optimization_flags"`
+	BufferSize Number `json:"buffer_size" xml:"buffer_size" metric:"php_jit_buffer_size_bytes"`
+	BufferFree Number `json:"buffer_free" xml:"buffer_free" metric:"php_jit_buffer_free_bytes"`
 }
 
 type APCU struct {
-	Cache Cache `json:"cache"`
-	SMA   SMA   `json:"sma"`
+	Cache Cache `json:"cache" xml:"cache"`
+	SMA   SMA   `json:"sma" xml:"sma"`
 }
 
 type Cache struct {
-	NumSlots   float64 `json:"num_slots" metric:"php_apcu_cache_slots"`
-	TTL        float64 `json:"ttl" metric:"php_apcu_cache_ttl"`
-	NumHits    float64 `json:"num_hits" metric:"php_apcu_cache_hits_count"`
-	NumMisses  float64 `json:"num_misses" metric:"php_apcu_cache_misses_count"`
-	NumInserts float64 `json:"num_inserts" metric:"php_apcu_cache_inserts_count"`
-	NumEntries float64 `json:"num_entries" metric:"php_apcu_cache_entries"`
-	Expunges   float64 `json:"expunges" metric:"php_apcu_cache_expunges_count"`
-	StartTime  float64 `json:"start_time" metric:"php_apcu_cache_start_time_ticks"`
-	MemSize    float64 `json:"mem_size" metric:"php_apcu_cache_memory_free_bytes"`
-	MemoryType string  `json:"memory_type" metric:"php_apcu_cache_memory_type"`
+	NumSlots   Number `json:"num_slots" xml:"num_slots" metric:"php_apcu_cache_slots"`
+	TTL        Number `json:"ttl" xml:"ttl" metric:"php_apcu_cache_ttl"`
+	NumHits    Number `json:"num_hits" xml:"num_hits" metric:"php_apcu_cache_hits_total" created:"StartTime"`
+	NumMisses  Number `json:"num_misses" xml:"num_misses" metric:"php_apcu_cache_misses_total" created:"StartTime"`
+	NumInserts Number `json:"num_inserts" xml:"num_inserts" metric:"php_apcu_cache_inserts_total" created:"StartTime"`
+	NumEntries Number `json:"num_entries" xml:"num_entries" metric:"php_apcu_cache_entries"`
+	Expunges   Number `json:"expunges" xml:"expunges" metric:"php_apcu_cache_expunges_total" created:"StartTime"`
+	StartTime  Number `json:"start_time" xml:"start_time" metric:"php_apcu_cache_start_time_ticks"`
+	MemSize    Number `json:"mem_size" xml:"mem_size" metric:"php_apcu_cache_memory_free_bytes"`
+	MemoryType string `json:"memory_type" xml:"memory_type" metric:"php_apcu_cache_memory_type" info:"php_apcu_cache_info" infolabel:"memory_type"`
 }
 
 type SMA struct {
-	NumSeg   float64 `json:"num_seg" metric:"php_apcu_sma_seg"`
-	SegSize  float64 `json:"seg_size" metric:"php_apcu_sma_seg_size_bytes"`
-	AvailMem float64 `json:"avail_mem" metric:"php_apcu_sma_memory_free_bytes"`
+	NumSeg   Number `json:"num_seg" xml:"num_seg" metric:"php_apcu_sma_seg"`
+	SegSize  Number `json:"seg_size" xml:"seg_size" metric:"php_apcu_sma_seg_size_bytes"`
+	AvailMem Number `json:"avail_mem" xml:"avail_mem" metric:"php_apcu_sma_memory_free_bytes"`
 }
 
 type Database struct {
-	Type    string  `json:"type" metric:"database_type"`
-	Version string  `json:"version" metric:"database_version"`
-	Size    float64 `json:"size" metric:"database_size_bytes"`
+	Type    string `json:"type" xml:"type" metric:"database_type" info:"build_info" infolabel:"database_type"`
+	Version string `json:"version" xml:"version" metric:"database_version" info:"build_info" infolabel:"database_version"`
+	// Nil if the size is missing or can't be parsed, so it isn't exported as 0
+	Size *Number `json:"size" xml:"size" metric:"database_size_bytes"`
 }
 
 // Raw database data.
-// Required to convert size to float64
+// Size may be reported as a number or a string, so it's parsed separately
 type intermediateDatabase struct {
-	Type    string `json:"type"`
-	Version string `json:"version"`
-	Size    string `json:"size"`
+	Type    string          `json:"type"`
+	Version string          `json:"version"`
+	Size    json.RawMessage `json:"size"`
+}
+
+type intermediateXMLDatabase struct {
+	Type    string  `xml:"type"`
+	Version string  `xml:"version"`
+	Size    *string `xml:"size"`
 }
 
 func (db *Database) UnmarshalJSON(data []byte) error {
 	var inter intermediateDatabase
-	err := json.Unmarshal(data, &inter)
-	if err != nil {
+	if err := json.Unmarshal(data, &inter); err != nil {
+		warnDecode("database", err)
 		return nil
 	}
 
-	size, err := strconv.ParseFloat(inter.Size, 64)
-	if err != nil {
+	db.Type = inter.Type
+	db.Version = inter.Version
+	if len(inter.Size) == 0 || string(inter.Size) == "null" || string(inter.Size) == `""` {
+		return nil
+	}
+
+	var size Number
+	if err := json.Unmarshal(inter.Size, &size); err != nil {
+		warnDecode("database.size", err)
+		return nil
+	}
+	db.Size = &size
+	return nil
+}
+
+func (db *Database) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var inter intermediateXMLDatabase
+	if err := d.DecodeElement(&inter, &start); err != nil {
+		warnDecode("database", err)
 		return nil
 	}
 
 	db.Type = inter.Type
 	db.Version = inter.Version
-	db.Size = size
+	if inter.Size == nil || strings.TrimSpace(*inter.Size) == "" {
+		return nil
+	}
 
+	size, err := parseNumber(*inter.Size)
+	if err != nil {
+		warnDecode("database.size", err)
+		return nil
+	}
+	db.Size = &size
 	return nil
 }
 
 type ActiveUsers struct {
-	Last5Minutes float64 `json:"last5minutes" metric:"active_users" label:"5min"`
-	Last1Hour    float64 `json:"last1hour" metric:"active_users" label:"60min"`
-	Last24Hours  float64 `json:"last24hours" metric:"active_users" label:"1440min"`
+	Last5Minutes Number `json:"last5minutes" xml:"last5minutes" metric:"active_users" label:"5min"`
+	Last1Hour    Number `json:"last1hour" xml:"last1hour" metric:"active_users" label:"60min"`
+	Last24Hours  Number `json:"last24hours" xml:"last24hours" metric:"active_users" label:"1440min"`
 }
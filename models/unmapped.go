@@ -0,0 +1,89 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// JSON paths in a serverinfo response that aren't mapped to any field of `NCServerInfo`.
+// New fields show up here when Nextcloud adds them, so they can be exported in future
+func UnmappedFields(data []byte) ([]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var raw interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]struct{})
+	collectUnmapped(reflect.TypeOf(NCServerInfo{}), raw, "", found)
+
+	unmapped := make([]string, 0, len(found))
+	for path := range found {
+		unmapped = append(unmapped, path)
+	}
+	sort.Strings(unmapped)
+	return unmapped, nil
+}
+
+func collectUnmapped(t reflect.Type, v interface{}, path string, unmapped map[string]struct{}) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		// Structs decoded from other shapes (e.g. CPU load lists) are leaves
+		object, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for key, child := range object {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+
+			field, ok := jsonField(t, canonicalKey(key))
+			if !ok {
+				unmapped[childPath] = struct{}{}
+				continue
+			}
+			collectUnmapped(field.Type, child, childPath, unmapped)
+		}
+	case reflect.Map:
+		if object, ok := v.(map[string]interface{}); ok {
+			for key, child := range object {
+				collectUnmapped(t.Elem(), child, path+"."+key, unmapped)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if list, ok := v.([]interface{}); ok {
+			for _, child := range list {
+				collectUnmapped(t.Elem(), child, path+"[]", unmapped)
+			}
+		}
+	}
+}
+
+// Find the field of a struct decoded from a JSON key
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for fi := 0; fi < t.NumField(); fi++ {
+		field := t.Field(fi)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/MAKLs/nextcloud-exporter/metrics"
+)
+
+// Kinds of decode problems already logged, by field and error type.
+// Keyed on the type rather than the message, which includes the offending value
+var loggedWarnings sync.Map
+
+// Record a value that couldn't be decoded but doesn't fail the scrape.
+// Every occurrence is counted, but each kind of problem with a field is only logged once
+func warnDecode(field string, err error) {
+	metrics.DecodeWarnings.WithLabelValues(field).Inc()
+
+	kind := fmt.Sprintf("%T", err)
+	if _, logged := loggedWarnings.LoadOrStore(field+"\xff"+kind, struct{}{}); !logged {
+		log.Printf("ignoring undecodable %s: %v", field, err)
+	}
+}
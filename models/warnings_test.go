@@ -0,0 +1,39 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/MAKLs/nextcloud-exporter/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func loggedWarningCount() int {
+	count := 0
+	loggedWarnings.Range(func(key, value interface{}) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+func TestWarnDecodeDedup(t *testing.T) {
+	warnings := testutil.ToFloat64(metrics.DecodeWarnings.WithLabelValues("database.size"))
+	logged := loggedWarningCount()
+
+	// The offending value changes between scrapes
+	for i := 0; i < 10; i++ {
+		var db Database
+		if err := json.Unmarshal([]byte(fmt.Sprintf(`{"type":"mysql","size":"%d MB"}`, i)), &db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := testutil.ToFloat64(metrics.DecodeWarnings.WithLabelValues("database.size")) - warnings; got != 10 {
+		t.Errorf("counted %v warnings, want 10", got)
+	}
+	if got := loggedWarningCount() - logged; got > 1 {
+		t.Errorf("recorded %d distinct warnings for one kind of problem, want at most 1", got)
+	}
+}
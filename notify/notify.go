@@ -0,0 +1,145 @@
+package notify
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/MAKLs/nextcloud-exporter/config"
+)
+
+const sendTimeout = 10 * time.Second
+
+// Change in whether a target is up, or a reminder that it's still down
+type Event struct {
+	Target string `json:"target"`
+	Up     bool   `json:"up"`
+	// Error that took the target down, empty once it recovers
+	Error string `json:"error,omitempty"`
+	// When the target entered its current state
+	Since  time.Time `json:"since"`
+	Repeat bool      `json:"repeat"`
+}
+
+type sender interface {
+	name() string
+	send(event Event) error
+}
+
+// Sender whose notifications expire, so they're sent again on this interval while the target is down
+type resender interface {
+	sender
+	resendInterval() time.Duration
+}
+
+// Notifies senders when a target goes down or recovers.
+// A state has to persist for the debounce interval before it's reported,
+// and a target that stays down is reported again every repeat interval
+type Notifier struct {
+	lock           sync.Mutex
+	senders        []sender
+	debounce       time.Duration
+	repeatInterval time.Duration
+	// Last state reported, targets are assumed up until reported otherwise
+	reportedUp   bool
+	pending      bool
+	pendingSince time.Time
+	lastNotified time.Time
+	// When each sender was last sent an event, by index
+	lastSent []time.Time
+}
+
+// Create a notifier for the configured senders, or nil if none are configured
+func NewNotifier(conf *config.Config) *Notifier {
+	var senders []sender
+	if conf.NotifyWebhookUrl.Host != "" {
+		senders = append(senders, &webhookSender{url: conf.NotifyWebhookUrl})
+	}
+	if conf.NotifyAlertmanagerUrl.Host != "" {
+		senders = append(senders, &alertmanagerSender{url: conf.NotifyAlertmanagerUrl, resend: alertmanagerResendInterval})
+	}
+	if conf.NotifyTalkUrl.Host != "" {
+		senders = append(senders, &talkSender{url: conf.NotifyTalkUrl, token: conf.NotifyTalkToken, secret: conf.NotifyTalkSecret})
+	}
+	if len(senders) == 0 {
+		return nil
+	}
+
+	return &Notifier{
+		senders:        senders,
+		debounce:       conf.NotifyDebounce,
+		repeatInterval: conf.NotifyRepeatInterval,
+		reportedUp:     true,
+		lastSent:       make([]time.Time, len(senders)),
+	}
+}
+
+// Observe the outcome of a request to a target, notifying if its state changed
+func (n *Notifier) Observe(target string, err error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	now := time.Now()
+	up := err == nil
+	event := Event{Target: target, Up: up}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	if up == n.reportedUp {
+		n.pending = false
+		if up {
+			return
+		}
+
+		event.Since = n.pendingSince
+		if n.repeatInterval > 0 && now.Sub(n.lastNotified) >= n.repeatInterval {
+			event.Repeat = true
+			n.notify(event, now)
+		} else {
+			n.resend(event, now)
+		}
+		return
+	}
+
+	if !n.pending {
+		n.pending = true
+		n.pendingSince = now
+	}
+	if now.Sub(n.pendingSince) < n.debounce {
+		return
+	}
+
+	n.pending = false
+	n.reportedUp = up
+	event.Since = n.pendingSince
+	n.notify(event, now)
+}
+
+// Must be called with the lock held
+func (n *Notifier) notify(event Event, now time.Time) {
+	n.lastNotified = now
+	for i := range n.senders {
+		n.sendTo(i, event, now)
+	}
+}
+
+// Send a down event again to senders whose last notification is about to expire.
+// Must be called with the lock held
+func (n *Notifier) resend(event Event, now time.Time) {
+	for i, s := range n.senders {
+		if r, ok := s.(resender); ok && now.Sub(n.lastSent[i]) >= r.resendInterval() {
+			n.sendTo(i, event, now)
+		}
+	}
+}
+
+// Must be called with the lock held
+func (n *Notifier) sendTo(i int, event Event, now time.Time) {
+	n.lastSent[i] = now
+	go func(s sender) {
+		if err := s.send(event); err != nil {
+			log.Printf("failed to send %s notification: %v", s.name(), err)
+		}
+	}(n.senders[i])
+}
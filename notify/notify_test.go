@@ -0,0 +1,229 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MAKLs/nextcloud-exporter/config"
+	"github.com/MAKLs/nextcloud-exporter/internal/testserver"
+)
+
+const testTarget = "cloud.example.com"
+
+var errDown = errors.New("connection refused")
+
+func receiveEvent(t *testing.T, r *testserver.Receiver) Event {
+	t.Helper()
+
+	var event Event
+	if err := json.Unmarshal(r.Receive().Body, &event); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestNewNotifierWithoutSenders(t *testing.T) {
+	if n := NewNotifier(&config.Config{}); n != nil {
+		t.Errorf("expected no notifier without senders, got %+v", n)
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	r := testserver.NewReceiver(t)
+	n := NewNotifier(&config.Config{NotifyWebhookUrl: r.URL, NotifyDebounce: 100 * time.Millisecond})
+
+	// A failure shorter than the debounce interval isn't reported
+	n.Observe(testTarget, errDown)
+	n.Observe(testTarget, nil)
+	time.Sleep(120 * time.Millisecond)
+	n.Observe(testTarget, errDown)
+	r.ExpectNone()
+
+	// Down since the first failure of this outage
+	since := time.Now()
+	time.Sleep(120 * time.Millisecond)
+	n.Observe(testTarget, errDown)
+	event := receiveEvent(t, r)
+	if event.Up || event.Repeat || event.Target != testTarget || event.Error != errDown.Error() {
+		t.Errorf("unexpected down event: %+v", event)
+	}
+	if event.Since.After(since) {
+		t.Errorf("since = %s, want the first failure before %s", event.Since, since)
+	}
+
+	// Staying down isn't reported again without a repeat interval
+	n.Observe(testTarget, errDown)
+	r.ExpectNone()
+
+	n.Observe(testTarget, nil)
+	r.ExpectNone()
+	time.Sleep(120 * time.Millisecond)
+	n.Observe(testTarget, nil)
+	event = receiveEvent(t, r)
+	if !event.Up || event.Error != "" {
+		t.Errorf("unexpected recovery event: %+v", event)
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	r := testserver.NewReceiver(t)
+	n := NewNotifier(&config.Config{NotifyWebhookUrl: r.URL, NotifyRepeatInterval: 100 * time.Millisecond})
+
+	n.Observe(testTarget, errDown)
+	first := receiveEvent(t, r)
+	if first.Up || first.Repeat {
+		t.Errorf("unexpected down event: %+v", first)
+	}
+
+	n.Observe(testTarget, errDown)
+	r.ExpectNone()
+
+	time.Sleep(120 * time.Millisecond)
+	n.Observe(testTarget, errDown)
+	repeat := receiveEvent(t, r)
+	if repeat.Up || !repeat.Repeat {
+		t.Errorf("unexpected repeat event: %+v", repeat)
+	}
+	if !repeat.Since.Equal(first.Since) {
+		t.Errorf("repeat since = %s, want %s", repeat.Since, first.Since)
+	}
+
+	// Recovery ends the repeats
+	n.Observe(testTarget, nil)
+	if event := receiveEvent(t, r); !event.Up {
+		t.Errorf("unexpected recovery event: %+v", event)
+	}
+	time.Sleep(120 * time.Millisecond)
+	n.Observe(testTarget, nil)
+	r.ExpectNone()
+}
+
+func TestWebhookPayload(t *testing.T) {
+	r := testserver.NewReceiver(t)
+	webhookUrl := r.URL
+	webhookUrl.Path = "/hooks/nextcloud"
+	n := NewNotifier(&config.Config{NotifyWebhookUrl: webhookUrl})
+
+	n.Observe(testTarget, errDown)
+	req := r.Receive()
+	if req.Path != "/hooks/nextcloud" {
+		t.Errorf("path = %s, want /hooks/nextcloud", req.Path)
+	}
+	if contentType := req.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("content type = %s, want application/json", contentType)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(req.Body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"target", "up", "error", "since", "repeat"} {
+		if _, ok := payload[field]; !ok {
+			t.Errorf("payload is missing %s: %s", field, req.Body)
+		}
+	}
+}
+
+func TestAlertmanagerPayload(t *testing.T) {
+	r := testserver.NewReceiver(t)
+	n := NewNotifier(&config.Config{NotifyAlertmanagerUrl: r.URL})
+	resend := 100 * time.Millisecond
+	n.senders[0].(*alertmanagerSender).resend = resend
+
+	receiveAlert := func() alert {
+		t.Helper()
+
+		req := r.Receive()
+		if req.Path != alertsPath {
+			t.Errorf("path = %s, want %s", req.Path, alertsPath)
+		}
+		var alerts []alert
+		if err := json.Unmarshal(req.Body, &alerts); err != nil {
+			t.Fatal(err)
+		}
+		if len(alerts) != 1 {
+			t.Fatalf("expected 1 alert, got %s", req.Body)
+		}
+		return alerts[0]
+	}
+
+	n.Observe(testTarget, errDown)
+	firing := receiveAlert()
+	if firing.Labels["alertname"] != "NextcloudDown" || firing.Labels["target"] != testTarget {
+		t.Errorf("unexpected labels: %v", firing.Labels)
+	}
+	if firing.Annotations["description"] != errDown.Error() {
+		t.Errorf("unexpected annotations: %v", firing.Annotations)
+	}
+	if firing.StartsAt == nil || firing.EndsAt == nil {
+		t.Fatalf("firing alert needs startsAt and endsAt: %+v", firing)
+	}
+	if validFor := firing.EndsAt.Sub(*firing.StartsAt); validFor < 3*resend || validFor > 5*resend {
+		t.Errorf("alert valid for %s, want about %s", validFor, 4*resend)
+	}
+
+	// Refreshed every resend interval while down, even without a repeat interval
+	n.Observe(testTarget, errDown)
+	r.ExpectNone()
+	time.Sleep(resend + 20*time.Millisecond)
+	n.Observe(testTarget, errDown)
+	refreshed := receiveAlert()
+	if refreshed.StartsAt == nil || !refreshed.StartsAt.Equal(*firing.StartsAt) {
+		t.Errorf("refreshed startsAt = %v, want %s", refreshed.StartsAt, firing.StartsAt)
+	}
+	if refreshed.EndsAt == nil || !refreshed.EndsAt.After(*firing.EndsAt) {
+		t.Errorf("refreshed endsAt = %v, want after %s", refreshed.EndsAt, firing.EndsAt)
+	}
+
+	n.Observe(testTarget, nil)
+	resolved := receiveAlert()
+	if resolved.StartsAt != nil || resolved.EndsAt == nil || resolved.EndsAt.After(time.Now()) {
+		t.Errorf("unexpected resolved alert: %+v", resolved)
+	}
+	if resolved.Labels["alertname"] != "NextcloudDown" || resolved.Labels["target"] != testTarget {
+		t.Errorf("resolved alert labels %v don't match the firing alert", resolved.Labels)
+	}
+}
+
+func TestTalkPayload(t *testing.T) {
+	r := testserver.NewReceiver(t)
+	const (
+		token  = "k7b3x9q2"
+		secret = "0123456789abcdef0123456789abcdef0123456789"
+	)
+	n := NewNotifier(&config.Config{NotifyTalkUrl: r.URL, NotifyTalkToken: token, NotifyTalkSecret: secret})
+
+	n.Observe(testTarget, errDown)
+	req := r.Receive()
+	if want := "/ocs/v2.php/apps/spreed/api/v1/bot/" + token + "/message"; req.Path != want {
+		t.Errorf("path = %s, want %s", req.Path, want)
+	}
+	if req.Header.Get("OCS-APIRequest") != "true" {
+		t.Errorf("missing OCS-APIRequest header")
+	}
+
+	var payload struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(req.Body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Nextcloud " + testTarget + " is down: " + errDown.Error(); payload.Message != want {
+		t.Errorf("message = %q, want %q", payload.Message, want)
+	}
+
+	random := req.Header.Get("X-Nextcloud-Talk-Bot-Random")
+	if len(random) != 64 {
+		t.Errorf("random = %q, want 64 hex characters", random)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(random + payload.Message))
+	if signature := req.Header.Get("X-Nextcloud-Talk-Bot-Signature"); signature != hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("signature %s doesn't match the random value and message", signature)
+	}
+}
@@ -0,0 +1,163 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: sendTimeout}
+
+func postJSON(target string, body interface{}, headers map[string]string) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response: %s", res.Status)
+	}
+	return nil
+}
+
+func summary(event Event) string {
+	switch {
+	case event.Up:
+		return fmt.Sprintf("Nextcloud %s recovered", event.Target)
+	case event.Repeat:
+		return fmt.Sprintf("Nextcloud %s is still down since %s: %s", event.Target, event.Since.Format(time.RFC3339), event.Error)
+	default:
+		return fmt.Sprintf("Nextcloud %s is down: %s", event.Target, event.Error)
+	}
+}
+
+// Posts events as JSON
+type webhookSender struct {
+	url url.URL
+}
+
+func (s *webhookSender) name() string {
+	return "webhook"
+}
+
+func (s *webhookSender) send(event Event) error {
+	return postJSON(s.url.String(), event, nil)
+}
+
+// Posts alerts to the Alertmanager v2 API, resolving them on recovery.
+// Alertmanager resolves alerts that aren't refreshed, so firing alerts are sent again
+// while the target is down, each valid for a few resend intervals as Prometheus does
+type alertmanagerSender struct {
+	url    url.URL
+	resend time.Duration
+}
+
+const (
+	alertsPath                 = "/api/v2/alerts"
+	alertmanagerResendInterval = time.Minute
+)
+
+type alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    *time.Time        `json:"startsAt,omitempty"`
+	EndsAt      *time.Time        `json:"endsAt,omitempty"`
+}
+
+func (s *alertmanagerSender) name() string {
+	return "alertmanager"
+}
+
+func (s *alertmanagerSender) resendInterval() time.Duration {
+	return s.resend
+}
+
+func (s *alertmanagerSender) send(event Event) error {
+	endpoint, err := s.url.Parse(alertsPath)
+	if err != nil {
+		return err
+	}
+
+	firing := alert{
+		Labels: map[string]string{
+			"alertname": "NextcloudDown",
+			"target":    event.Target,
+			"severity":  "critical",
+		},
+		Annotations: map[string]string{
+			"summary":     summary(event),
+			"description": event.Error,
+		},
+	}
+	if event.Up {
+		// Resolve the alert raised when the target went down
+		endsAt := time.Now()
+		firing.EndsAt = &endsAt
+	} else {
+		// Expires unless it's sent again, in case the exporter stops observing the target
+		endsAt := time.Now().Add(4 * s.resend)
+		firing.StartsAt = &event.Since
+		firing.EndsAt = &endsAt
+	}
+
+	return postJSON(endpoint.String(), []alert{firing}, nil)
+}
+
+// Posts messages to a Nextcloud Talk room through the bot API
+type talkSender struct {
+	url    url.URL
+	token  string
+	secret string
+}
+
+const talkBotPath = "/ocs/v2.php/apps/spreed/api/v1/bot/%s/message"
+
+func (s *talkSender) name() string {
+	return "talk"
+}
+
+func (s *talkSender) send(event Event) error {
+	endpoint, err := s.url.Parse(fmt.Sprintf(talkBotPath, url.PathEscape(s.token)))
+	if err != nil {
+		return err
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(random)
+
+	// Requests are signed with the random value followed by the message
+	message := summary(event)
+	mac := hmac.New(sha256.New, []byte(s.secret))
+	mac.Write([]byte(nonce + message))
+
+	return postJSON(endpoint.String(), map[string]string{"message": message}, map[string]string{
+		"OCS-APIRequest":                 "true",
+		"X-Nextcloud-Talk-Bot-Random":    nonce,
+		"X-Nextcloud-Talk-Bot-Signature": hex.EncodeToString(mac.Sum(nil)),
+	})
+}
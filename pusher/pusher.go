@@ -0,0 +1,109 @@
+package pusher
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/MAKLs/nextcloud-exporter/config"
+	"github.com/MAKLs/nextcloud-exporter/web"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// Label identifying the target in the grouping key
+const instanceLabel = "instance"
+
+// Pushes metrics to a Pushgateway on an interval, for targets Prometheus can't reach
+type Pusher struct {
+	pusher   *push.Pusher
+	client   *http.Client
+	url      string
+	interval time.Duration
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// Create a pusher for the configured Pushgateway, or nil if none is configured
+func NewPusher(conf *config.Config, gatherer prometheus.Gatherer, target string) *Pusher {
+	if conf.PushGatewayUrl.Host == "" {
+		return nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = web.MustClientTLSConfig(&conf.PushTLSConfig)
+
+	client := &http.Client{Transport: transport, Timeout: conf.PushInterval}
+	pusher := push.New(conf.PushGatewayUrl.String(), conf.PushJob).
+		Gatherer(gatherer).
+		Client(client).
+		Grouping(instanceLabel, target)
+	for name, value := range conf.PushGrouping {
+		pusher = pusher.Grouping(name, value)
+	}
+	if conf.PushUsername != "" {
+		pusher = pusher.BasicAuth(conf.PushUsername, conf.PushPassword)
+	}
+
+	return &Pusher{
+		pusher:   pusher,
+		client:   client,
+		url:      conf.PushGatewayUrl.Redacted(),
+		interval: conf.PushInterval,
+	}
+}
+
+// Push metrics on every interval until stopped
+func (p *Pusher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		log.Printf("pushing metrics to %s every %s", p.url, p.interval)
+		for {
+			// Push replaces the whole group, so metrics that disappear are removed
+			if err := p.pusher.PushContext(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("failed to push metrics: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop pushing. The group is kept, so it's replaced by the next push after a reload
+func (p *Pusher) Stop() {
+	p.cancel()
+	<-p.done
+}
+
+// Delete the group on shutdown, so stale metrics don't linger on the Pushgateway.
+// Must be called after Stop
+func (p *Pusher) Delete(ctx context.Context) {
+	// Delete takes no context, so the deadline is applied through the client
+	p.pusher.Client(contextDoer{ctx: ctx, client: p.client})
+	if err := p.pusher.Delete(); err != nil {
+		log.Printf("failed to delete metrics group from %s: %v", p.url, err)
+	}
+}
+
+// HTTP client sending every request with a context
+type contextDoer struct {
+	ctx    context.Context
+	client *http.Client
+}
+
+func (d contextDoer) Do(req *http.Request) (*http.Response, error) {
+	return d.client.Do(req.WithContext(d.ctx))
+}
@@ -0,0 +1,137 @@
+package remotewrite
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const metricNameLabel = "__name__"
+
+type label struct {
+	name  string
+	value string
+}
+
+// Series with a single sample, as gathered on one interval
+type sample struct {
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+// Convert gathered metric families to samples.
+// Histograms and summaries are split into their component series, as Prometheus stores them
+func toSamples(families []*dto.MetricFamily, timestamp int64) []sample {
+	var samples []sample
+	for _, family := range families {
+		name := family.GetName()
+		for _, metric := range family.GetMetric() {
+			ts := timestamp
+			if metric.TimestampMs != nil {
+				ts = metric.GetTimestampMs()
+			}
+			add := func(suffix string, value float64, extra ...label) {
+				samples = append(samples, sample{labels: seriesLabels(name+suffix, metric.GetLabel(), extra...), value: value, timestamp: ts})
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", metric.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", metric.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, quantile := range summary.GetQuantile() {
+					add("", quantile.GetValue(), label{"quantile", formatFloat(quantile.GetQuantile())})
+				}
+				add("_sum", summary.GetSampleSum())
+				add("_count", float64(summary.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				infSeen := false
+				for _, bucket := range histogram.GetBucket() {
+					if math.IsInf(bucket.GetUpperBound(), 1) {
+						infSeen = true
+					}
+					add("_bucket", float64(bucket.GetCumulativeCount()), label{"le", formatFloat(bucket.GetUpperBound())})
+				}
+				if !infSeen {
+					add("_bucket", float64(histogram.GetSampleCount()), label{"le", "+Inf"})
+				}
+				add("_sum", histogram.GetSampleSum())
+				add("_count", float64(histogram.GetSampleCount()))
+			}
+		}
+	}
+	return samples
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Labels of a series sorted by name, as remote_write requires
+func seriesLabels(name string, pairs []*dto.LabelPair, extra ...label) []label {
+	labels := make([]label, 0, len(pairs)+len(extra)+1)
+	labels = append(labels, label{metricNameLabel, name})
+	for _, pair := range pairs {
+		labels = append(labels, label{pair.GetName(), pair.GetValue()})
+	}
+	labels = append(labels, extra...)
+
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].name < labels[j].name
+	})
+	return labels
+}
+
+// Field numbers of the remote_write protobuf messages
+const (
+	writeRequestTimeseries = 1
+	timeSeriesLabels       = 1
+	timeSeriesSamples      = 2
+	labelName              = 1
+	labelValue             = 2
+	sampleValue            = 1
+	sampleTimestamp        = 2
+)
+
+// Encode samples as a remote_write `WriteRequest` protobuf message
+func encodeWriteRequest(samples []sample) []byte {
+	var request []byte
+	for _, s := range samples {
+		var series []byte
+		for _, l := range s.labels {
+			var encodedLabel []byte
+			encodedLabel = protowire.AppendTag(encodedLabel, labelName, protowire.BytesType)
+			encodedLabel = protowire.AppendString(encodedLabel, l.name)
+			encodedLabel = protowire.AppendTag(encodedLabel, labelValue, protowire.BytesType)
+			encodedLabel = protowire.AppendString(encodedLabel, l.value)
+
+			series = protowire.AppendTag(series, timeSeriesLabels, protowire.BytesType)
+			series = protowire.AppendBytes(series, encodedLabel)
+		}
+
+		var encodedSample []byte
+		encodedSample = protowire.AppendTag(encodedSample, sampleValue, protowire.Fixed64Type)
+		encodedSample = protowire.AppendFixed64(encodedSample, math.Float64bits(s.value))
+		encodedSample = protowire.AppendTag(encodedSample, sampleTimestamp, protowire.VarintType)
+		encodedSample = protowire.AppendVarint(encodedSample, uint64(s.timestamp))
+
+		series = protowire.AppendTag(series, timeSeriesSamples, protowire.BytesType)
+		series = protowire.AppendBytes(series, encodedSample)
+
+		request = protowire.AppendTag(request, writeRequestTimeseries, protowire.BytesType)
+		request = protowire.AppendBytes(request, series)
+	}
+	return request
+}
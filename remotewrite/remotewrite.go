@@ -0,0 +1,241 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/MAKLs/nextcloud-exporter/config"
+	"github.com/MAKLs/nextcloud-exporter/metrics"
+	"github.com/MAKLs/nextcloud-exporter/version"
+	"github.com/MAKLs/nextcloud-exporter/web"
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const remoteWriteVersion = "0.1.0"
+
+var userAgent = fmt.Sprintf("nextcloud-exporter/%s", version.Version)
+
+// Error from a request the receiver rejected, which would fail the same way if retried
+type rejectedError struct {
+	status string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("remote write rejected: %s", e.status)
+}
+
+// Gathers metrics on an interval and sends them through the remote_write protocol.
+// Samples are buffered in memory while the receiver is unavailable, up to the queue capacity
+type Sender struct {
+	url        string
+	redacted   string
+	httpClient *http.Client
+	username   string
+	password   string
+	gatherer   prometheus.Gatherer
+	interval   time.Duration
+	maxPerSend int
+	retries    uint
+	backoff    time.Duration
+
+	lock     sync.Mutex
+	queue    []sample
+	capacity int
+	wake     chan struct{}
+
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+}
+
+// Create a sender for the configured receiver, or nil if none is configured
+func NewSender(conf *config.Config, gatherer prometheus.Gatherer) *Sender {
+	if conf.RemoteWriteUrl.Host == "" {
+		return nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = web.MustClientTLSConfig(&conf.RemoteWriteTLSConfig)
+
+	maxPerSend := int(conf.RemoteWriteMaxSamplesPerSend)
+	if maxPerSend == 0 {
+		maxPerSend = int(conf.RemoteWriteQueueCapacity)
+	}
+
+	return &Sender{
+		url:        conf.RemoteWriteUrl.String(),
+		redacted:   conf.RemoteWriteUrl.Redacted(),
+		httpClient: &http.Client{Transport: transport, Timeout: conf.RemoteWriteTimeout},
+		username:   conf.RemoteWriteUsername,
+		password:   conf.RemoteWritePassword,
+		gatherer:   gatherer,
+		interval:   conf.RemoteWriteInterval,
+		maxPerSend: maxPerSend,
+		retries:    conf.RemoteWriteRetries,
+		backoff:    conf.RemoteWriteRetryBackoff,
+		capacity:   int(conf.RemoteWriteQueueCapacity),
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Gather and send metrics until stopped
+func (s *Sender) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	log.Printf("sending metrics to %s every %s", s.redacted, s.interval)
+	s.done.Add(2)
+	go s.gatherLoop(ctx)
+	go s.sendLoop(ctx)
+}
+
+// Stop gathering and sending. Samples still queued are dropped
+func (s *Sender) Stop() {
+	s.cancel()
+	s.done.Wait()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	metrics.RemoteWriteSamplesDropped.WithLabelValues(metrics.DropShutdown).Add(float64(len(s.queue)))
+	s.queue = nil
+	metrics.RemoteWritePendingSamples.Set(0)
+}
+
+func (s *Sender) gatherLoop(ctx context.Context) {
+	defer s.done.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		families, err := s.gatherer.Gather()
+		if err != nil {
+			log.Printf("failed to gather metrics for remote write: %v", err)
+		}
+		s.enqueue(toSamples(families, time.Now().UnixMilli()))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Queue samples to send, dropping the oldest beyond the queue capacity
+func (s *Sender) enqueue(samples []sample) {
+	s.lock.Lock()
+	s.queue = append(s.queue, samples...)
+	if overflow := len(s.queue) - s.capacity; overflow > 0 {
+		metrics.RemoteWriteSamplesDropped.WithLabelValues(metrics.DropQueueFull).Add(float64(overflow))
+		s.queue = append([]sample(nil), s.queue[overflow:]...)
+	}
+	metrics.RemoteWritePendingSamples.Set(float64(len(s.queue)))
+	s.lock.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Take up to the maximum number of samples per request from the queue
+func (s *Sender) dequeue() []sample {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	n := len(s.queue)
+	if n > s.maxPerSend {
+		n = s.maxPerSend
+	}
+	batch := s.queue[:n:n]
+	s.queue = s.queue[n:]
+	metrics.RemoteWritePendingSamples.Set(float64(len(s.queue)))
+	return batch
+}
+
+func (s *Sender) sendLoop(ctx context.Context) {
+	defer s.done.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		}
+
+		for batch := s.dequeue(); len(batch) > 0; batch = s.dequeue() {
+			err := s.sendWithRetries(ctx, batch)
+			switch err.(type) {
+			case nil:
+				metrics.RemoteWriteSamplesSent.Add(float64(len(batch)))
+				continue
+			case *rejectedError:
+				metrics.RemoteWriteSamplesDropped.WithLabelValues(metrics.DropRejected).Add(float64(len(batch)))
+			default:
+				metrics.RemoteWriteSamplesDropped.WithLabelValues(metrics.DropRetriesExhausted).Add(float64(len(batch)))
+			}
+			log.Printf("dropped %d samples: %v", len(batch), err)
+
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Sender) sendWithRetries(ctx context.Context, batch []sample) error {
+	body := snappy.Encode(nil, encodeWriteRequest(batch))
+
+	wait := s.backoff
+	for attempt := uint(0); ; attempt++ {
+		err := s.send(ctx, body)
+		if _, rejected := err.(*rejectedError); err == nil || rejected || attempt >= s.retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func (s *Sender) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", remoteWriteVersion)
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	switch {
+	case res.StatusCode/100 == 2:
+		return nil
+	// Server errors and rate limiting may succeed later
+	case res.StatusCode/100 == 5, res.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("remote write failed: %s", res.Status)
+	default:
+		return &rejectedError{status: res.Status}
+	}
+}
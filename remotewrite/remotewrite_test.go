@@ -0,0 +1,387 @@
+package remotewrite
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MAKLs/nextcloud-exporter/config"
+	"github.com/MAKLs/nextcloud-exporter/internal/testserver"
+	"github.com/MAKLs/nextcloud-exporter/metrics"
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type testSample struct {
+	value     float64
+	timestamp int64
+}
+
+type timeSeries struct {
+	labels  []label
+	samples []testSample
+}
+
+// Call fn with each field of a protobuf message, along with its encoded value
+func eachField(data []byte, fn func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		if err := fn(num, typ, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// Embedded message or string of a length-delimited field
+func fieldBytes(typ protowire.Type, value []byte) ([]byte, error) {
+	if typ != protowire.BytesType {
+		return nil, fmt.Errorf("unexpected wire type %d", typ)
+	}
+	b, n := protowire.ConsumeBytes(value)
+	if n < 0 {
+		return nil, protowire.ParseError(n)
+	}
+	return b, nil
+}
+
+// Decode a WriteRequest, with field numbers from the remote_write protobuf definition
+func decodeWriteRequest(data []byte) ([]timeSeries, error) {
+	var request []timeSeries
+	err := eachField(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 1 {
+			return fmt.Errorf("unexpected WriteRequest field %d", num)
+		}
+		encodedSeries, err := fieldBytes(typ, value)
+		if err != nil {
+			return err
+		}
+
+		var series timeSeries
+		err = eachField(encodedSeries, func(num protowire.Number, typ protowire.Type, value []byte) error {
+			message, err := fieldBytes(typ, value)
+			if err != nil {
+				return err
+			}
+
+			switch num {
+			case 1:
+				var l label
+				err = eachField(message, func(num protowire.Number, typ protowire.Type, value []byte) error {
+					s, err := fieldBytes(typ, value)
+					switch num {
+					case 1:
+						l.name = string(s)
+					case 2:
+						l.value = string(s)
+					default:
+						return fmt.Errorf("unexpected Label field %d", num)
+					}
+					return err
+				})
+				series.labels = append(series.labels, l)
+			case 2:
+				var s testSample
+				err = eachField(message, func(num protowire.Number, typ protowire.Type, value []byte) error {
+					switch {
+					case num == 1 && typ == protowire.Fixed64Type:
+						v, _ := protowire.ConsumeFixed64(value)
+						s.value = math.Float64frombits(v)
+					case num == 2 && typ == protowire.VarintType:
+						v, _ := protowire.ConsumeVarint(value)
+						s.timestamp = int64(v)
+					default:
+						return fmt.Errorf("unexpected Sample field %d of wire type %d", num, typ)
+					}
+					return nil
+				})
+				series.samples = append(series.samples, s)
+			default:
+				return fmt.Errorf("unexpected TimeSeries field %d", num)
+			}
+			return err
+		})
+		request = append(request, series)
+		return err
+	})
+	return request, err
+}
+
+// Receive a remote write request, decoding its body
+func receiveWrite(t *testing.T, r *testserver.Receiver) (testserver.Request, []timeSeries) {
+	t.Helper()
+
+	req := r.Receive()
+	body, err := snappy.Decode(nil, req.Body)
+	if err != nil {
+		t.Fatalf("request body isn't snappy-compressed: %v", err)
+	}
+	series, err := decodeWriteRequest(body)
+	if err != nil {
+		t.Fatalf("request body isn't a WriteRequest: %v", err)
+	}
+	return req, series
+}
+
+// Start a fake remote_write receiver, answering with the given status codes in turn
+func newReceiver(t *testing.T, statuses ...int) (*testserver.Receiver, url.URL) {
+	t.Helper()
+
+	r := testserver.NewReceiver(t, statuses...)
+	writeUrl := r.URL
+	writeUrl.Path = "/api/v1/write"
+	return r, writeUrl
+}
+
+func testConfig(writeUrl url.URL) *config.Config {
+	return &config.Config{
+		RemoteWriteUrl: writeUrl,
+		// Samples are gathered once on start, tests don't wait for another interval
+		RemoteWriteInterval:          time.Hour,
+		RemoteWriteQueueCapacity:     1000,
+		RemoteWriteMaxSamplesPerSend: 1000,
+		RemoteWriteRetries:           3,
+		RemoteWriteRetryBackoff:      time.Millisecond,
+		RemoteWriteTimeout:           time.Second,
+	}
+}
+
+func gaugeRegistry(t *testing.T) *prometheus.Registry {
+	t.Helper()
+
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_gauge", Help: "Test gauge."})
+	gauge.Set(1)
+	registry.MustRegister(gauge)
+	return registry
+}
+
+func startSender(t *testing.T, conf *config.Config, gatherer prometheus.Gatherer) *Sender {
+	t.Helper()
+
+	sender := NewSender(conf, gatherer)
+	sender.Start()
+	t.Cleanup(sender.Stop)
+	return sender
+}
+
+// Series as `name{label="value",...}` by their labels in order, along with their value
+func seriesByKey(t *testing.T, request []timeSeries) map[string]float64 {
+	t.Helper()
+
+	series := make(map[string]float64, len(request))
+	for _, ts := range request {
+		names := make([]string, 0, len(ts.labels))
+		var (
+			name  string
+			pairs []string
+		)
+		for _, l := range ts.labels {
+			names = append(names, l.name)
+			if l.name == metricNameLabel {
+				name = l.value
+			} else {
+				pairs = append(pairs, l.name+`="`+l.value+`"`)
+			}
+		}
+		if !sort.StringsAreSorted(names) {
+			t.Errorf("labels of %s aren't sorted: %v", name, names)
+		}
+		if len(ts.samples) != 1 {
+			t.Errorf("%s has %d samples, want 1", name, len(ts.samples))
+			continue
+		}
+		if ts.samples[0].timestamp <= 0 {
+			t.Errorf("%s has no timestamp", name)
+		}
+		series[name+"{"+strings.Join(pairs, ",")+"}"] = ts.samples[0].value
+	}
+	return series
+}
+
+func TestSend(t *testing.T) {
+	r, writeUrl := newReceiver(t)
+
+	registry := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_requests_total", Help: "Test counter."}, []string{"zone", "app"})
+	requests.WithLabelValues("eu", "files").Add(3)
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_latency_seconds", Help: "Test histogram.", Buckets: []float64{0.1, 1}})
+	for _, v := range []float64{0.05, 0.5, 5} {
+		latency.Observe(v)
+	}
+	size := prometheus.NewSummary(prometheus.SummaryOpts{Name: "test_size_bytes", Help: "Test summary.", Objectives: map[float64]float64{0.5: 0.05}})
+	for _, v := range []float64{1, 2, 3} {
+		size.Observe(v)
+	}
+	registry.MustRegister(requests, latency, size)
+
+	conf := testConfig(writeUrl)
+	conf.RemoteWriteUsername = "writer"
+	conf.RemoteWritePassword = "secret"
+	sent := testutil.ToFloat64(metrics.RemoteWriteSamplesSent)
+	startSender(t, conf, registry)
+
+	req, body := receiveWrite(t, r)
+	for header, want := range map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	} {
+		if got := req.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	if username, password, ok := (&http.Request{Header: req.Header}).BasicAuth(); !ok || username != "writer" || password != "secret" {
+		t.Errorf("basic auth = %s:%s, want writer:secret", username, password)
+	}
+	if !strings.HasPrefix(req.Header.Get("User-Agent"), "nextcloud-exporter/") {
+		t.Errorf("user agent = %q", req.Header.Get("User-Agent"))
+	}
+
+	want := map[string]float64{
+		`test_requests_total{app="files",zone="eu"}`: 3,
+		`test_latency_seconds_bucket{le="0.1"}`:      1,
+		`test_latency_seconds_bucket{le="1"}`:        2,
+		`test_latency_seconds_bucket{le="+Inf"}`:     3,
+		`test_latency_seconds_sum{}`:                 5.55,
+		`test_latency_seconds_count{}`:               3,
+		`test_size_bytes{quantile="0.5"}`:            2,
+		`test_size_bytes_sum{}`:                      6,
+		`test_size_bytes_count{}`:                    3,
+	}
+	got := seriesByKey(t, body)
+	if len(got) != len(want) {
+		t.Errorf("got %d series, want %d: %v", len(got), len(want), got)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+
+	testserver.WaitFor(t, func() bool { return testutil.ToFloat64(metrics.RemoteWriteSamplesSent)-sent == float64(len(want)) })
+}
+
+func TestRetry(t *testing.T) {
+	r, writeUrl := newReceiver(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+	sent := testutil.ToFloat64(metrics.RemoteWriteSamplesSent)
+	startSender(t, testConfig(writeUrl), gaugeRegistry(t))
+
+	// The same batch is sent until it's accepted
+	_, first := receiveWrite(t, r)
+	for i := 0; i < 2; i++ {
+		_, retried := receiveWrite(t, r)
+		if len(retried) != len(first) {
+			t.Errorf("retry %d sent %d series, want %d", i+1, len(retried), len(first))
+		}
+	}
+	r.ExpectNone()
+
+	testserver.WaitFor(t, func() bool { return testutil.ToFloat64(metrics.RemoteWriteSamplesSent)-sent == 1 })
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	r, writeUrl := newReceiver(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	conf := testConfig(writeUrl)
+	conf.RemoteWriteRetries = 2
+	dropped := testutil.ToFloat64(metrics.RemoteWriteSamplesDropped.WithLabelValues(metrics.DropRetriesExhausted))
+	startSender(t, conf, gaugeRegistry(t))
+
+	for i := 0; i < 3; i++ {
+		r.Receive()
+	}
+	r.ExpectNone()
+
+	testserver.WaitFor(t, func() bool {
+		return testutil.ToFloat64(metrics.RemoteWriteSamplesDropped.WithLabelValues(metrics.DropRetriesExhausted))-dropped == 1
+	})
+}
+
+func TestRejected(t *testing.T) {
+	r, writeUrl := newReceiver(t, http.StatusBadRequest)
+	dropped := testutil.ToFloat64(metrics.RemoteWriteSamplesDropped.WithLabelValues(metrics.DropRejected))
+	startSender(t, testConfig(writeUrl), gaugeRegistry(t))
+
+	// Rejected batches aren't retried
+	r.Receive()
+	r.ExpectNone()
+
+	testserver.WaitFor(t, func() bool {
+		return testutil.ToFloat64(metrics.RemoteWriteSamplesDropped.WithLabelValues(metrics.DropRejected))-dropped == 1
+	})
+}
+
+func TestQueueFull(t *testing.T) {
+	_, writeUrl := newReceiver(t)
+	conf := testConfig(writeUrl)
+	conf.RemoteWriteQueueCapacity = 3
+	// Not started, so queued samples stay in the queue
+	sender := NewSender(conf, gaugeRegistry(t))
+	dropped := testutil.ToFloat64(metrics.RemoteWriteSamplesDropped.WithLabelValues(metrics.DropQueueFull))
+
+	samples := make([]sample, 5)
+	for i := range samples {
+		samples[i] = sample{labels: []label{{metricNameLabel, "test_gauge"}}, value: float64(i), timestamp: int64(i + 1)}
+	}
+	sender.enqueue(samples[:2])
+	sender.enqueue(samples[2:])
+
+	// The oldest samples are dropped
+	if len(sender.queue) != 3 {
+		t.Fatalf("queue holds %d samples, want 3", len(sender.queue))
+	}
+	for i, s := range sender.queue {
+		if want := float64(i + 2); s.value != want {
+			t.Errorf("queued sample %d = %v, want %v", i, s.value, want)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.RemoteWriteSamplesDropped.WithLabelValues(metrics.DropQueueFull)) - dropped; got != 2 {
+		t.Errorf("dropped %v samples for a full queue, want 2", got)
+	}
+	if pending := testutil.ToFloat64(metrics.RemoteWritePendingSamples); pending != 3 {
+		t.Errorf("pending samples = %v, want 3", pending)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	r, writeUrl := newReceiver(t)
+	conf := testConfig(writeUrl)
+	conf.RemoteWriteMaxSamplesPerSend = 2
+
+	registry := prometheus.NewRegistry()
+	gauges := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_gauge", Help: "Test gauge."}, []string{"index"})
+	for _, index := range []string{"0", "1", "2", "3", "4"} {
+		gauges.WithLabelValues(index).Set(1)
+	}
+	registry.MustRegister(gauges)
+	startSender(t, conf, registry)
+
+	for _, want := range []int{2, 2, 1} {
+		if _, body := receiveWrite(t, r); len(body) != want {
+			t.Errorf("batch of %d series, want %d", len(body), want)
+		}
+	}
+}
+
+func TestNewSenderWithoutUrl(t *testing.T) {
+	if sender := NewSender(&config.Config{}, prometheus.NewRegistry()); sender != nil {
+		t.Errorf("expected no sender without a URL, got %+v", sender)
+	}
+}
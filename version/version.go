@@ -0,0 +1,11 @@
+package version
+
+import "runtime"
+
+// Build information, set at build time through ldflags, e.g.
+// -X github.com/MAKLs/nextcloud-exporter/version.Version=v1.0.0
+var (
+	Version   = "dev"
+	Revision  = "unknown"
+	GoVersion = runtime.Version()
+)
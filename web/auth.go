@@ -0,0 +1,74 @@
+package web
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Compared against when a user doesn't exist, so unknown users take as long to reject
+var dummyHash = func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("nextcloud-exporter"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}()
+
+type basicAuthHandler struct {
+	users map[string][]byte
+	next  http.Handler
+	// Bcrypt is deliberately slow, so credentials already verified are remembered
+	verified sync.Map
+}
+
+// Require HTTP basic auth with bcrypt-hashed passwords for every request.
+// Without users, requests are passed through unauthenticated
+func MustBasicAuth(users map[string]string, next http.Handler) http.Handler {
+	if len(users) == 0 {
+		return next
+	}
+
+	handler := &basicAuthHandler{users: make(map[string][]byte, len(users)), next: next}
+	for user, hash := range users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			panic(fmt.Sprintf("invalid bcrypt hash for user '%s': %v", user, err))
+		}
+		// Config keys are case-insensitive, so usernames are too
+		handler.users[strings.ToLower(user)] = []byte(hash)
+	}
+	return handler
+}
+
+func (h *basicAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, password, ok := r.BasicAuth()
+	if ok && h.authenticate(strings.ToLower(user), password) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("WWW-Authenticate", `Basic realm="nextcloud-exporter"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func (h *basicAuthHandler) authenticate(user string, password string) bool {
+	hash, exists := h.users[user]
+	if !exists {
+		hash = dummyHash
+	}
+
+	key := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + string(hash)))
+	if _, ok := h.verified.Load(key); ok {
+		return exists
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || !exists {
+		return false
+	}
+	h.verified.Store(key, struct{}{})
+	return true
+}
@@ -0,0 +1,117 @@
+package web
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Prefixes of listen addresses other than host:port
+const (
+	unixPrefix = "unix:"
+	// Listen on every socket passed by systemd socket activation
+	systemdAddress = "systemd"
+)
+
+// First file descriptor passed by systemd
+const listenFdsStart = 3
+
+var (
+	activationOnce  sync.Once
+	activationFiles []*os.File
+)
+
+// Files passed by systemd socket activation.
+// They're kept open so listeners can be created from them again on every restart
+func systemdFiles() []*os.File {
+	activationOnce.Do(func() {
+		pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		if err != nil || pid != os.Getpid() {
+			return
+		}
+		fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil {
+			return
+		}
+
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		for i := 0; i < fds; i++ {
+			name := fmt.Sprintf("LISTEN_FD_%d", listenFdsStart+i)
+			if i < len(names) && names[i] != "" {
+				name = names[i]
+			}
+			activationFiles = append(activationFiles, os.NewFile(uintptr(listenFdsStart+i), name))
+		}
+
+		// Child processes must not inherit the sockets
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	})
+	return activationFiles
+}
+
+// Open listeners for a listen address.
+// Addresses are either host:port, `unix:` followed by a socket path,
+// or `systemd` for sockets passed by socket activation
+func Listen(address string) ([]net.Listener, error) {
+	switch {
+	case address == systemdAddress:
+		files := systemdFiles()
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no sockets passed by systemd")
+		}
+
+		listeners := make([]net.Listener, 0, len(files))
+		for _, file := range files {
+			listener, err := net.FileListener(file)
+			if err != nil {
+				closeListeners(listeners)
+				return nil, fmt.Errorf("failed to listen on systemd socket %s: %w", file.Name(), err)
+			}
+			listeners = append(listeners, listener)
+		}
+		return listeners, nil
+	case strings.HasPrefix(address, unixPrefix):
+		path := strings.TrimPrefix(address, unixPrefix)
+		// Remove a socket left behind by a previous run
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listener}, nil
+	default:
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listener}, nil
+	}
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
+
+// Open listeners for every listen address, closing them all if any fails
+func ListenAll(addresses []string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, address := range addresses {
+		opened, err := Listen(address)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, opened...)
+	}
+	return listeners, nil
+}
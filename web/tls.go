@@ -0,0 +1,119 @@
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/MAKLs/nextcloud-exporter/config"
+)
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+var tlsVersions = map[string]uint16{
+	"":      tls.VersionTLS12,
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+// Check whether endpoints should be served over TLS
+func TLSEnabled(conf *config.TLSServerConfig) bool {
+	return conf.CertFile != "" || conf.KeyFile != ""
+}
+
+// Build the TLS config for serving the exporter's endpoints.
+// Certificates and client CAs are read again for every connection,
+// so rotated files take effect without a restart
+func MustTLSConfig(conf *config.TLSServerConfig) *tls.Config {
+	if _, err := loadTLSConfig(conf); err != nil {
+		panic(fmt.Sprintf("invalid TLS server config: %v", err))
+	}
+
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return loadTLSConfig(conf)
+		},
+	}
+}
+
+func loadTLSConfig(conf *config.TLSServerConfig) (*tls.Config, error) {
+	if conf.CertFile == "" || conf.KeyFile == "" {
+		return nil, fmt.Errorf("both cert_file and key_file are required")
+	}
+
+	clientAuth, ok := clientAuthTypes[conf.ClientAuthType]
+	if !ok {
+		return nil, fmt.Errorf("unknown client_auth_type '%s'", conf.ClientAuthType)
+	}
+	minVersion, ok := tlsVersions[conf.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown min_version '%s'", conf.MinVersion)
+	}
+
+	cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuth,
+		MinVersion:   minVersion,
+	}
+
+	if conf.ClientCAFile != "" {
+		pem, err := os.ReadFile(conf.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", conf.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+	} else if clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("client_auth_type '%s' requires a client_ca_file", conf.ClientAuthType)
+	}
+
+	return tlsConfig, nil
+}
+
+// Build the TLS config for connecting to a remote endpoint
+func MustClientTLSConfig(conf *config.TLSClientConfig) *tls.Config {
+	tlsConfig := &tls.Config{
+		ServerName:         conf.ServerName,
+		InsecureSkipVerify: conf.InsecureSkipVerify,
+	}
+
+	if conf.CAFile != "" {
+		pem, err := os.ReadFile(conf.CAFile)
+		if err != nil {
+			panic(fmt.Sprintf("failed to read CA file: %v", err))
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			panic(fmt.Sprintf("no certificates found in CA file %s", conf.CAFile))
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if conf.CertFile != "" || conf.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			panic(fmt.Sprintf("failed to load client certificate: %v", err))
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig
+}